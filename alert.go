@@ -347,6 +347,9 @@ func newAlertNode(et *ExecutingTask, n *pipeline.AlertNode, d NodeDiagnostic) (a
 
 	for _, am := range n.AlertManagerHandlers {
 		c := et.tm.AlertManagerService.DefaultHandlerConfig()
+		if am.Room != "" {
+			c.Room = am.Room
+		}
 		if len(am.AlertManagerTagName) != 0{
 			c.AlertManagerTagName = am.AlertManagerTagName
 		}
@@ -360,7 +363,17 @@ func newAlertNode(et *ExecutingTask, n *pipeline.AlertNode, d NodeDiagnostic) (a
 			c.AlertManagerAnnotationValue = am.AlertManagerAnnotationValue
 		}
 		
-		h, err := et.tm.AlertManagerService.Handler(c,ctx...)
+		amCtx := append(ctx[:len(ctx):len(ctx)], keyvalue.KV("node", n.Name()))
+		if q := batchQuery(n); q != "" {
+			amCtx = append(amCtx, keyvalue.KV("query", q))
+		}
+		if len(et.Task.DBRPs) == 1 {
+			dbrp := et.Task.DBRPs[0]
+			amCtx = append(amCtx, keyvalue.KV("database", dbrp.Database), keyvalue.KV("rp", dbrp.RetentionPolicy))
+		}
+		// Count failed sends against the node when OnFailure is "propagate".
+		c.OnError = func(error) { an.incrementErrorCount() }
+		h, err := et.tm.AlertManagerService.Handler(c, amCtx...)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create alertmanager handler")
 		}
@@ -1183,6 +1196,19 @@ type detailsInfo struct {
 	Message string
 }
 
+// batchQuery returns the query of the batch node upstream of the node, if any.
+func batchQuery(n pipeline.Node) string {
+	for _, p := range n.Parents() {
+		if q, ok := p.(*pipeline.QueryNode); ok {
+			return q.QueryStr
+		}
+		if q := batchQuery(p); q != "" {
+			return q
+		}
+	}
+	return ""
+}
+
 func (n *AlertNode) serverInfo() serverInfo {
 	return serverInfo{
 		Hostname:  n.et.tm.ServerInfo.Hostname(),
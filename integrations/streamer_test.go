@@ -9015,20 +9015,12 @@ stream
 	exp := []interface{}{
 		alertmanagertest.Request{
 			URL: "/",
-			PostData: alertmanagertest.PostData{struct {
-				Status      string
-				Labels      map[string]string
-				Annotations map[string]string
-			}{Status: "firing", Labels: map[string]string{"resource":"serverA","alertname":"kapacitor/cpu/serverA"},
+			PostData: alertmanagertest.PostData{alertmanager.AlertManagerAlert{Status: "firing", Labels: map[string]string{"resource":"serverA","alertname":"kapacitor/cpu/serverA"},
 				Annotations: map[string]string{"boo1":"bar1","boo2":"bar2"}}},
 		},
 		alertmanagertest.Request{
 			URL: "/",
-			PostData: alertmanagertest.PostData{struct {
-				Status      string
-				Labels      map[string]string
-				Annotations map[string]string
-			}{Status: "firing", Labels: map[string]string{"foo1":"far1","foo2":"far2"},
+			PostData: alertmanagertest.PostData{alertmanager.AlertManagerAlert{Status: "firing", Labels: map[string]string{"foo1":"far1","foo2":"far2"},
 				Annotations: map[string]string{}}},
 		},
 	}
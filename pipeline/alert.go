@@ -1285,6 +1285,11 @@ func (a *AlertManagerHandler) AlertManagerAnnotationValues(alertManagerAnnotatio
 // tick:embedded:AlertNode.AlertManager
 type AlertManagerHandler struct {
 	*AlertNodeData `json:"-"`
+
+	// Routing value sent as the label named by the room-label option of the alertmanager configuration, "channel" by default.
+	// If empty no routing label is added.
+	Room string `json:"room"`
+
 	AlertManagerTagName []string `tick:"AlertManagerTagNames" json:"alertManagerTagName"`
 	AlertManagerTagValue []string `tick:"AlertManagerTagValues" json:"alertManagerTagValue"`
 	AlertManagerAnnotationName []string `tick:"AlertManagerAnnotationNames" json:"alertManagerAnnotationName"`
@@ -596,6 +596,7 @@ func TestAlertAlerta(t *testing.T) {
 func TestAlertAlertManager(t *testing.T) {
 	pipe, _, from := StreamFrom()
 	handler := from.Alert().AlertManager()
+	handler.Room = "ops"
 	handler.AlertManagerTagNames("foo1","foo2")
 	handler.AlertManagerTagValues("far1","far2")
 	handler.AlertManagerAnnotationNames("boo1","boo2")
@@ -608,6 +609,7 @@ func TestAlertAlertManager(t *testing.T) {
         .details('{{ json . }}')
         .history(21)
         .alertManager()
+        .room('ops')
         .alertManagerTagNames('foo1', 'foo2')
         .alertManagerTagValues('far1', 'far2')
         .alertManagerAnnotationNames('boo1', 'boo2')
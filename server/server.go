@@ -774,6 +774,7 @@ func (s *Server) appendAlertManagerService() {
 	c := s.config.AlertManager
 	d := s.DiagService.NewAlertManagerHandler()
 	srv :=  alertmanager.NewService(c, d)
+	srv.StorageService = s.StorageService
 
 	s.TaskMaster.AlertManagerService = srv
 	s.AlertService.AlertManagerService = srv
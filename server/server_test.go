@@ -8765,6 +8765,7 @@ func TestServer_ListServiceTests(t *testing.T) {
 						"ann_valueA",
 						"ann_valueB",
 					},
+					"indent": false,
 				},
 			},
 			{
@@ -790,7 +790,7 @@ func (s *Service) createHandlerFromSpec(spec HandlerSpec) (handler, error) {
 		if err != nil {
 			return handler{}, err
 		}
-		h, err  := s.AlertManagerService.Handler(c, ctx...)
+		h, err = s.AlertManagerService.Handler(c, ctx...)
 		if err != nil {
 			return handler{}, err
 		}
@@ -0,0 +1,75 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/kapacitor/alert"
+	"github.com/influxdata/kapacitor/keyvalue"
+	"github.com/influxdata/kapacitor/services/alertmanager"
+)
+
+type alertManagerDiag struct{}
+
+func (d alertManagerDiag) WithContext(ctx ...keyvalue.T) alertmanager.Diagnostic { return d }
+func (alertManagerDiag) TemplateError(err error, kv keyvalue.T)                  {}
+func (alertManagerDiag) Error(msg string, err error)                             {}
+func (alertManagerDiag) Warn(msg string, ctx ...keyvalue.T)                      {}
+func (alertManagerDiag) Debug(msg string, ctx ...keyvalue.T)                     {}
+
+func TestService_CreateHandlerFromSpec_AlertManager(t *testing.T) {
+	var mu sync.Mutex
+	var posted []alertmanager.PostAlertManager
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		var alerts alertmanager.PostAlertManager
+		json.Unmarshal(data, &alerts)
+		mu.Lock()
+		posted = append(posted, alerts)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	c := alertmanager.NewConfig()
+	c.Enabled = true
+	c.URL = ts.URL
+	c.IncludeHandler = true
+	s := NewService(nil)
+	s.AlertManagerService = alertmanager.NewService(c, alertManagerDiag{})
+
+	h, err := s.createHandlerFromSpec(HandlerSpec{
+		ID:    "ops-alertmanager",
+		Topic: "cpu",
+		Kind:  "alertmanager",
+		Options: map[string]interface{}{
+			"room":       "ops",
+			"thresholds": map[string]interface{}{"critical": "90"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Handler == nil {
+		t.Fatal("expected an alertmanager handler")
+	}
+	h.Handler.Handle(alert.Event{State: alert.EventState{ID: "cpu", Level: alert.Critical}})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, exp := len(posted), 1; got != exp {
+		t.Fatalf("unexpected request count: got %d exp %d", got, exp)
+	}
+	a := posted[0][0]
+	for name, exp := range map[string]string{"handler": "ops-alertmanager", "channel": "ops"} {
+		if got := a.Labels[name]; got != exp {
+			t.Errorf("unexpected %s label: got %q exp %q", name, got, exp)
+		}
+	}
+	if got, exp := a.Annotations["threshold"], "90"; got != exp {
+		t.Errorf("unexpected threshold annotation: got %q exp %q", got, exp)
+	}
+}
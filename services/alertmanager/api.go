@@ -0,0 +1,185 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+const jsonContentType = "application/json"
+
+// alertsEndpoints are the paths of the Alertmanager alerts endpoint the configured URL may end with.
+var alertsEndpoints = []string{"/api/v2/alerts", "/api/v1/alerts"}
+
+// apiURL returns the URL of the Alertmanager API endpoint at path with the query, relative to the configured URL.
+// The configured path is kept as a prefix without its alerts endpoint, so both https://host/am/
+// and https://host/am/api/v2/alerts resolve to https://host/am/api/v2/status for the status path.
+func (c Config) apiURL(path string, query url.Values) (string, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", c.URL, err)
+	}
+	prefix := strings.TrimSuffix(u.Path, "/")
+	for _, endpoint := range alertsEndpoints {
+		if strings.HasSuffix(prefix, endpoint) {
+			prefix = strings.TrimSuffix(prefix, endpoint)
+			break
+		}
+	}
+	u.Path = prefix + path
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// getJSON reads the Alertmanager API endpoint at path with the query and decodes the JSON response into v.
+// Responses that are not JSON, for example an HTML error page from a proxy, are rejected.
+func (s *Service) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	st := s.settings()
+	c := st.config
+	if !c.Enabled {
+		return errors.New("service is not enabled")
+	}
+	u, err := c.apiURL(path, query)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if p, ok := receiverPresets[c.Receiver]; ok {
+		for k, v := range p.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", jsonContentType)
+
+	r, err := st.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return s.classifyError(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		io.Copy(ioutil.Discard, r.Body)
+		return &statusCodeError{StatusCode: r.StatusCode}
+	}
+	if ct := r.Header.Get("Content-Type"); !isJSON(ct) {
+		return fmt.Errorf("unexpected content type %q from Alertmanager service at %s, expected %s", ct, u, jsonContentType)
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response from Alertmanager service at %s: %v", u, err)
+	}
+	return nil
+}
+
+// isJSON reports whether the Content-Type header value denotes JSON.
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == jsonContentType
+}
+
+const statusPath = "/api/v2/status"
+
+// AlertmanagerStatus is the cluster and version information reported by Alertmanager.
+type AlertmanagerStatus struct {
+	Cluster     ClusterStatus `json:"cluster"`
+	VersionInfo VersionInfo   `json:"versionInfo"`
+	Uptime      time.Time     `json:"uptime"`
+}
+
+// ClusterStatus describes the Alertmanager cluster.
+type ClusterStatus struct {
+	Name   string       `json:"name"`
+	Status string       `json:"status"`
+	Peers  []PeerStatus `json:"peers"`
+}
+
+// PeerStatus describes a member of the Alertmanager cluster.
+type PeerStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// VersionInfo describes the Alertmanager build.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch"`
+	BuildUser string `json:"buildUser"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Status queries the Alertmanager status endpoint,
+// confirming connectivity and reporting the Alertmanager version and cluster peers.
+func (s *Service) Status() (AlertmanagerStatus, error) {
+	var status AlertmanagerStatus
+	if err := s.getJSON(context.Background(), statusPath, nil, &status); err != nil {
+		return AlertmanagerStatus{}, err
+	}
+	return status, nil
+}
+
+const alertsPath = "/api/v2/alerts"
+
+// gettableAlert is an alert as listed by the Alertmanager alerts endpoint.
+type gettableAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// ActiveAlerts lists the alerts currently known to Alertmanager,
+// filtered by label matchers such as `alertname="cpu"` or `severity=~"critical|warning"`.
+// Alertmanager only lists alerts that have not resolved, so their status is firing.
+func (s *Service) ActiveAlerts(filter []string) ([]AlertManagerAlert, error) {
+	query := make(url.Values)
+	for _, m := range filter {
+		query.Add("filter", m)
+	}
+	var listed []gettableAlert
+	if err := s.getJSON(context.Background(), alertsPath, query, &listed); err != nil {
+		return nil, err
+	}
+	alerts := make([]AlertManagerAlert, len(listed))
+	for i, a := range listed {
+		alerts[i] = AlertManagerAlert{
+			Status:      statusFiring,
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+			StartsAt:    a.StartsAt,
+			EndsAt:      a.EndsAt,
+		}
+	}
+	return alerts, nil
+}
+
+// checkTimeout bounds the connectivity check run by Open and Test.
+const checkTimeout = 10 * time.Second
+
+// checkConnectivity queries the status endpoint, logging a warning if Alertmanager cannot be reached.
+func (s *Service) checkConnectivity() {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	var status AlertmanagerStatus
+	if err := s.getJSON(ctx, statusPath, nil, &status); err != nil {
+		s.diag.Warn("Alertmanager connectivity check failed", keyvalue.KV("error", err.Error()))
+		return
+	}
+	s.diag.Debug("Alertmanager connectivity check succeeded", keyvalue.KV("version", status.VersionInfo.Version))
+}
@@ -0,0 +1,132 @@
+package alertmanager
+
+import (
+	"sync"
+
+	"github.com/influxdata/kapacitor/expvar"
+)
+
+const (
+	statQueueDepth     = "queue_depth"
+	statQueueHighWater = "queue_high_water"
+	statQueueDropped   = "queue_dropped"
+)
+
+// queuedDelivery is a payload of a handler waiting to be delivered.
+type queuedDelivery struct {
+	h      *handler
+	alerts PostAlertManager
+}
+
+// deliveryQueue is the bounded in-memory queue of payloads delivered in the background, oldest first.
+// Its depth and the highest depth seen are published as gauges.
+type deliveryQueue struct {
+	mu    sync.Mutex
+	items []queuedDelivery
+	// ready is signalled when items are pushed.
+	ready chan struct{}
+
+	depth     *expvar.Int
+	highWater *expvar.Int
+}
+
+func newDeliveryQueue(statMap *expvar.Map) *deliveryQueue {
+	q := &deliveryQueue{
+		ready:     make(chan struct{}, 1),
+		depth:     &expvar.Int{},
+		highWater: &expvar.Int{},
+	}
+	statMap.Set(statQueueDepth, q.depth)
+	statMap.Set(statQueueHighWater, q.highWater)
+	return q
+}
+
+// push appends the payload to the queue, dropping the oldest entries to stay within size.
+// It returns the number of dropped entries.
+func (q *deliveryQueue) push(size int, d queuedDelivery) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, d)
+	dropped := 0
+	if over := len(q.items) - size; over > 0 {
+		q.items = append(q.items[:0:0], q.items[over:]...)
+		dropped = over
+	}
+	depth := int64(len(q.items))
+	q.depth.Set(depth)
+	if depth > q.highWater.IntValue() {
+		q.highWater.Set(depth)
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// pop removes and returns the oldest payload, ok is false when the queue is empty.
+func (q *deliveryQueue) pop() (d queuedDelivery, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return queuedDelivery{}, false
+	}
+	d = q.items[0]
+	q.items[0] = queuedDelivery{}
+	q.items = q.items[1:]
+	q.depth.Set(int64(len(q.items)))
+	return d, true
+}
+
+// enqueue queues the payload of h for the delivery worker.
+func (s *Service) enqueue(c Config, h *handler, alerts PostAlertManager) {
+	if dropped := s.queue.push(c.AsyncQueueSize, queuedDelivery{h: h, alerts: alerts}); dropped > 0 {
+		s.statMap.Add(statQueueDropped, int64(dropped))
+	}
+}
+
+// drainQueue delivers the queued payloads until the queue is empty.
+func (s *Service) drainQueue() {
+	for {
+		d, ok := s.queue.pop()
+		if !ok {
+			return
+		}
+		d.h.deliver(s.config(), d.alerts)
+	}
+}
+
+// startAsync starts delivering queued payloads until stopAsync is called.
+// Payloads still queued when it stops are delivered before the worker exits.
+// The caller must hold s.mu.
+func (s *Service) startAsync(c Config) {
+	if !c.Enabled || !c.AsyncDelivery {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.asyncStop, s.asyncDone = stop, done
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				s.drainQueue()
+				return
+			case <-s.queue.ready:
+				s.drainQueue()
+			}
+		}
+	}()
+}
+
+// stopAsync stops any running delivery worker and waits for it to exit,
+// so that a worker started next never delivers alongside it. The caller must hold s.mu.
+func (s *Service) stopAsync() {
+	if s.asyncStop != nil {
+		close(s.asyncStop)
+		<-s.asyncDone
+		s.asyncStop, s.asyncDone = nil, nil
+	}
+}
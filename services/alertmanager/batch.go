@@ -0,0 +1,182 @@
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+// batchKeyData is the data of the BatchKey template.
+type batchKeyData struct {
+	// TaskName is the name of the task that sent the alert.
+	TaskName string
+	// Labels are the labels of the alert.
+	Labels map[string]string
+}
+
+// batcher buffers the payloads of handlers by batch key until they are flushed together.
+type batcher struct {
+	mu      sync.Mutex
+	buffers map[string][]queuedDelivery
+	// sizes are the number of buffered alerts, by batch key.
+	sizes map[string]int
+}
+
+func newBatcher() *batcher {
+	return &batcher{
+		buffers: make(map[string][]queuedDelivery),
+		sizes:   make(map[string]int),
+	}
+}
+
+// add buffers the payload under key. Once the buffer holds size alerts it is removed and returned to be flushed.
+func (b *batcher) add(key string, size int, d queuedDelivery) []queuedDelivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffers[key] = append(b.buffers[key], d)
+	b.sizes[key] += len(d.alerts)
+	if b.sizes[key] < size {
+		return nil
+	}
+	full := b.buffers[key]
+	delete(b.buffers, key)
+	delete(b.sizes, key)
+	return full
+}
+
+// take removes and returns every buffer, ordered by batch key.
+func (b *batcher) take() [][]queuedDelivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.buffers))
+	for k := range b.buffers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	batches := make([][]queuedDelivery, len(keys))
+	for i, k := range keys {
+		batches[i] = b.buffers[k]
+	}
+	b.buffers = make(map[string][]queuedDelivery)
+	b.sizes = make(map[string]int)
+	return batches
+}
+
+// batchKey renders the BatchKey template for the first alert of the payload.
+// A template error puts the alert in the batch with the empty key.
+func (s *Service) batchKey(c Config, alerts PostAlertManager) string {
+	if c.BatchKey == "" || len(alerts) == 0 {
+		return ""
+	}
+	t, err := loadTemplate(&s.batchKeyTmpl, "batch-key", c.BatchKey)
+	if err != nil {
+		s.diag.Error("failed to parse batch-key", err)
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, batchKeyData{TaskName: alerts[0].task, Labels: alerts[0].Labels}); err != nil {
+		s.diag.TemplateError(err, keyvalue.KV("batchKey", c.BatchKey))
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// relate sets the related annotation of each alert to the IDs of the other alerts
+// sharing its group, the values of the group labels.
+// Alerts without an ID or outside of any group are left as is.
+func relate(alerts PostAlertManager, groupBy []string) {
+	groups := make(map[string][]string)
+	for _, a := range alerts {
+		if g := groupValue(a.Labels, groupBy); a.id != "" && g != "" {
+			groups[g] = append(groups[g], a.id)
+		}
+	}
+	for _, a := range alerts {
+		g := groupValue(a.Labels, groupBy)
+		if a.id == "" || g == "" {
+			continue
+		}
+		var related []string
+		seen := map[string]bool{a.id: true}
+		for _, id := range groups[g] {
+			if !seen[id] {
+				seen[id] = true
+				related = append(related, id)
+			}
+		}
+		if len(related) == 0 {
+			continue
+		}
+		sort.Strings(related)
+		setDefault(a.Annotations, relatedAnnotation, strings.Join(related, ","))
+	}
+}
+
+// batch buffers the payload of h with the payloads sharing its batch key,
+// flushing the batch once it holds BatchSize alerts.
+func (s *Service) batch(c Config, h *handler, alerts PostAlertManager) {
+	if full := s.batches.add(s.batchKey(c, alerts), c.BatchSize, queuedDelivery{h: h, alerts: alerts}); full != nil {
+		s.flushBatch(c, full)
+	}
+}
+
+// flushBatch sends the buffered payloads in a single request,
+// recording the outcome for the handler of each payload.
+func (s *Service) flushBatch(c Config, batch []queuedDelivery) {
+	var alerts PostAlertManager
+	for _, d := range batch {
+		alerts = append(alerts, d.alerts...)
+	}
+	now := s.clock.Now()
+	err := s.post(context.Background(), alerts, sendOptions{})
+	for _, d := range batch {
+		d.h.delivered(c, d.alerts, now, err)
+	}
+}
+
+// flushBatches flushes every buffered batch.
+func (s *Service) flushBatches() {
+	c := s.config()
+	for _, batch := range s.batches.take() {
+		s.flushBatch(c, batch)
+	}
+}
+
+// startBatch starts flushing batches on the configured interval until stopBatch is called.
+// Batches still buffered when it stops are flushed before it exits.
+// The caller must hold s.mu.
+func (s *Service) startBatch(c Config) {
+	if !c.Enabled || c.BatchInterval <= 0 {
+		return
+	}
+	t := s.clock.NewTicker(time.Duration(c.BatchInterval))
+	stop := make(chan struct{})
+	s.batchStop = stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				s.flushBatches()
+				return
+			case <-t.C():
+				s.flushBatches()
+			}
+		}
+	}()
+}
+
+// stopBatch stops any running batch flushing. The caller must hold s.mu.
+func (s *Service) stopBatch() {
+	if s.batchStop != nil {
+		close(s.batchStop)
+		s.batchStop = nil
+	}
+}
@@ -0,0 +1,300 @@
+package alertmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+// webhookVersion is the version of the Alertmanager webhook envelope.
+const webhookVersion = "4"
+
+// webhookMessage is the envelope Alertmanager sends to webhook receivers.
+type webhookMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            interface{}       `json:"alerts"`
+}
+
+// newWebhookMessage wraps the encoded form of the alerts in the webhook envelope.
+// The alerts are grouped by their common labels, the envelope is firing if any of them is.
+func newWebhookMessage(alerts PostAlertManager, encoded interface{}) webhookMessage {
+	common := commonValues(alerts, func(a AlertManagerAlert) map[string]string { return a.Labels })
+	status := statusResolved
+	if firing(alerts) {
+		status = statusFiring
+	}
+	return webhookMessage{
+		Version:           webhookVersion,
+		GroupKey:          "{}:" + labelSet(common),
+		Status:            status,
+		GroupLabels:       common,
+		CommonLabels:      common,
+		CommonAnnotations: commonValues(alerts, func(a AlertManagerAlert) map[string]string { return a.Annotations }),
+		Alerts:            encoded,
+	}
+}
+
+// commonValues returns the pairs of values shared by all of the alerts.
+func commonValues(alerts PostAlertManager, values func(AlertManagerAlert) map[string]string) map[string]string {
+	common := make(map[string]string)
+	if len(alerts) == 0 {
+		return common
+	}
+	for k, v := range values(alerts[0]) {
+		common[k] = v
+	}
+	for _, a := range alerts[1:] {
+		m := values(a)
+		for k, v := range common {
+			if w, ok := m[k]; !ok || w != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+// labelSet formats the labels as Alertmanager does in group keys, e.g. {alertname="cpu", host="a"}.
+func labelSet(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// encode marshals the alerts into the request body, naming the status key StatusFieldName
+// and formatting timestamps according to TimeFormat.
+func encode(c Config, alerts PostAlertManager, opts sendOptions) ([]byte, error) {
+	if c.PayloadFormat == PayloadFormatForm {
+		return encodeForm(alerts, c.StatusFieldName, c.TimeFormat), nil
+	}
+	var v interface{} = alerts
+	renameStatus := c.StatusFieldName != "" && c.StatusFieldName != defaultStatusFieldName
+	if renameStatus || epochTime(c.TimeFormat) {
+		rewritten, err := rewriteFields(alerts, c.StatusFieldName, c.TimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		v = rewritten
+	}
+	if c.PayloadFormat == PayloadFormatWebhook {
+		v = newWebhookMessage(alerts, v)
+	}
+	if opts.indent {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
+
+// encodeForm flattens the alerts into form fields prefixed with alerts[i].,
+// labels and annotations are named labels.<name> and annotations.<name>.
+// Unset timestamps are omitted.
+func encodeForm(alerts PostAlertManager, statusName, timeFormat string) []byte {
+	if statusName == "" {
+		statusName = defaultStatusFieldName
+	}
+	form := make(url.Values)
+	for i, a := range alerts {
+		prefix := "alerts[" + strconv.Itoa(i) + "]."
+		form.Set(prefix+statusName, a.Status)
+		for k, v := range a.Labels {
+			form.Set(prefix+"labels."+k, v)
+		}
+		for k, v := range a.Annotations {
+			form.Set(prefix+"annotations."+k, v)
+		}
+		if !a.StartsAt.IsZero() {
+			form.Set(prefix+"startsAt", formatTime(a.StartsAt, timeFormat))
+		}
+		if !a.EndsAt.IsZero() {
+			form.Set(prefix+"endsAt", formatTime(a.EndsAt, timeFormat))
+		}
+	}
+	return []byte(form.Encode())
+}
+
+// contentType returns the Content-Type header value of the request body.
+func contentType(c Config) string {
+	if c.PayloadFormat == PayloadFormatForm {
+		return "application/x-www-form-urlencoded"
+	}
+	return "application/json"
+}
+
+// epochTime reports whether the time format writes timestamps as numbers.
+func epochTime(format string) bool {
+	return format == TimeFormatUnix || format == TimeFormatUnixMilli
+}
+
+// formatTime formats t in the time format, RFC3339 with nanoseconds unless it is an epoch format.
+func formatTime(t time.Time, format string) string {
+	switch format {
+	case TimeFormatUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TimeFormatUnixMilli:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	default:
+		return t.Format(time.RFC3339Nano)
+	}
+}
+
+// rewriteFields returns the JSON objects of the alerts with the status key renamed to name
+// and, for an epoch time format, the timestamps written as numbers.
+func rewriteFields(alerts PostAlertManager, name, timeFormat string) ([]map[string]json.RawMessage, error) {
+	if name == "" {
+		name = defaultStatusFieldName
+	}
+	rewritten := make([]map[string]json.RawMessage, len(alerts))
+	for i, a := range alerts {
+		data, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		if name != defaultStatusFieldName {
+			fields[name] = fields[defaultStatusFieldName]
+			delete(fields, defaultStatusFieldName)
+		}
+		if epochTime(timeFormat) {
+			if !a.StartsAt.IsZero() {
+				fields["startsAt"] = json.RawMessage(formatTime(a.StartsAt, timeFormat))
+			}
+			if !a.EndsAt.IsZero() {
+				fields["endsAt"] = json.RawMessage(formatTime(a.EndsAt, timeFormat))
+			}
+		}
+		rewritten[i] = fields
+	}
+	return rewritten, nil
+}
+
+// roundStartsAt returns a copy of the alerts with each set startsAt rounded to the nearest multiple of d.
+func roundStartsAt(alerts PostAlertManager, d time.Duration) PostAlertManager {
+	rounded := make(PostAlertManager, len(alerts))
+	for i, a := range alerts {
+		if !a.StartsAt.IsZero() {
+			a.StartsAt = a.StartsAt.Round(d)
+		}
+		rounded[i] = a
+	}
+	return rounded
+}
+
+// sanitize returns a copy of the alerts with values that cannot be marshalled removed:
+// timestamps outside the years 0 to 9999 are cleared and invalid UTF-8 is replaced.
+func sanitize(alerts PostAlertManager) PostAlertManager {
+	sanitized := make(PostAlertManager, len(alerts))
+	for i, a := range alerts {
+		a.Labels = sanitizeStrings(a.Labels)
+		a.Annotations = sanitizeStrings(a.Annotations)
+		a.Status = strings.ToValidUTF8(a.Status, "\uFFFD")
+		if !validTime(a.StartsAt) {
+			a.StartsAt = time.Time{}
+		}
+		if !validTime(a.EndsAt) {
+			a.EndsAt = time.Time{}
+		}
+		sanitized[i] = a
+	}
+	return sanitized
+}
+
+func sanitizeStrings(m map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(m))
+	for k, v := range m {
+		sanitized[strings.ToValidUTF8(k, "\uFFFD")] = strings.ToValidUTF8(v, "\uFFFD")
+	}
+	return sanitized
+}
+
+// validTime reports whether t can be marshalled as an RFC 3339 timestamp.
+func validTime(t time.Time) bool {
+	y := t.Year()
+	return y >= 0 && y <= 9999
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body.
+func sign(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postSplit sends each half of a group whose body exceeds MaxBodyBytes separately,
+// returning the first error.
+func (s *Service) postSplit(ctx context.Context, c Config, preset receiverPreset, g *alertGroup, now time.Time, opts sendOptions) error {
+	half := len(g.Alerts) / 2
+	var firstErr error
+	for _, part := range []*alertGroup{
+		{URL: g.URL, Alerts: g.Alerts[:half], DedupKeys: g.DedupKeys[:half]},
+		{URL: g.URL, Alerts: g.Alerts[half:], DedupKeys: g.DedupKeys[half:]},
+	} {
+		if err := s.postGroup(ctx, c, preset, part, now, opts); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// truncate drops the largest annotations of a single alert until its body fits within MaxBodyBytes.
+// An error is returned if the body is still too large once all annotations have been dropped.
+func (s *Service) truncate(c Config, alerts PostAlertManager, opts sendOptions) (PostAlertManager, []byte, error) {
+	a := alerts[0]
+	annotations := make(map[string]string, len(a.Annotations))
+	for k, v := range a.Annotations {
+		annotations[k] = v
+	}
+	a.Annotations = annotations
+	truncated := PostAlertManager{a}
+
+	var dropped []string
+	for {
+		data, err := encode(c, truncated, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(data) <= c.MaxBodyBytes {
+			s.diag.Warn("payload exceeds max-body-bytes, dropped annotations",
+				keyvalue.KV("annotations", strings.Join(dropped, ",")))
+			return truncated, data, nil
+		}
+		largest := ""
+		for k, v := range annotations {
+			if l := len(annotations[largest]); largest == "" || len(v) > l || (len(v) == l && k < largest) {
+				largest = k
+			}
+		}
+		if largest == "" {
+			return nil, nil, fmt.Errorf("payload of %d bytes exceeds max-body-bytes %d without annotations", len(data), c.MaxBodyBytes)
+		}
+		delete(annotations, largest)
+		dropped = append(dropped, largest)
+	}
+}
@@ -0,0 +1,132 @@
+package alertmanager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/influxdata/kapacitor/expvar"
+)
+
+const (
+	statMaxLabels      = "max_labels"
+	statAvgLabels      = "avg_labels"
+	statMaxAnnotations = "max_annotations"
+	statAvgAnnotations = "avg_annotations"
+)
+
+// cardinalityStats tracks the maximum and average number of labels and annotations
+// per alert over a fixed window and publishes them as gauges.
+type cardinalityStats struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int64
+	labels      int64
+	annotations int64
+
+	maxLabels      *expvar.Int
+	avgLabels      *expvar.Float
+	maxAnnotations *expvar.Int
+	avgAnnotations *expvar.Float
+}
+
+func newCardinalityStats(statMap *expvar.Map) *cardinalityStats {
+	cs := &cardinalityStats{
+		maxLabels:      &expvar.Int{},
+		avgLabels:      &expvar.Float{},
+		maxAnnotations: &expvar.Int{},
+		avgAnnotations: &expvar.Float{},
+	}
+	statMap.Set(statMaxLabels, cs.maxLabels)
+	statMap.Set(statAvgLabels, cs.avgLabels)
+	statMap.Set(statMaxAnnotations, cs.maxAnnotations)
+	statMap.Set(statAvgAnnotations, cs.avgAnnotations)
+	return cs
+}
+
+// observe records the label and annotation counts of the alerts.
+// A new window is started once the current one is older than window.
+func (cs *cardinalityStats) observe(alerts PostAlertManager, now time.Time, window time.Duration) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.count == 0 || now.Sub(cs.windowStart) >= window {
+		cs.windowStart = now
+		cs.count = 0
+		cs.labels = 0
+		cs.annotations = 0
+		cs.maxLabels.Set(0)
+		cs.maxAnnotations.Set(0)
+	}
+	for _, a := range alerts {
+		l, an := int64(len(a.Labels)), int64(len(a.Annotations))
+		cs.count++
+		cs.labels += l
+		cs.annotations += an
+		if l > cs.maxLabels.IntValue() {
+			cs.maxLabels.Set(l)
+		}
+		if an > cs.maxAnnotations.IntValue() {
+			cs.maxAnnotations.Set(an)
+		}
+	}
+	if cs.count > 0 {
+		cs.avgLabels.Set(float64(cs.labels) / float64(cs.count))
+		cs.avgAnnotations.Set(float64(cs.annotations) / float64(cs.count))
+	}
+}
+
+// labelGuard stops forwarding labels with more distinct values than allowed within a window.
+type labelGuard struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	values      map[string]map[string]bool
+	dropped     map[string]bool
+}
+
+func newLabelGuard() *labelGuard {
+	return &labelGuard{
+		values:  make(map[string]map[string]bool),
+		dropped: make(map[string]bool),
+	}
+}
+
+// filter returns the alerts without the labels that exceeded max distinct values in the current window,
+// along with the names of labels newly dropped. The alertname label is never dropped.
+// A new window is started once the current one is older than window.
+func (g *labelGuard) filter(alerts PostAlertManager, max int, now time.Time, window time.Duration) (PostAlertManager, []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.windowStart) >= window {
+		g.windowStart = now
+		g.values = make(map[string]map[string]bool)
+		g.dropped = make(map[string]bool)
+	}
+	var newlyDropped []string
+	filtered := make(PostAlertManager, len(alerts))
+	for i, a := range alerts {
+		for k, v := range a.Labels {
+			if k == alertNameLabel || g.dropped[k] {
+				continue
+			}
+			vs := g.values[k]
+			if vs == nil {
+				vs = make(map[string]bool)
+				g.values[k] = vs
+			}
+			vs[v] = true
+			if len(vs) > max {
+				g.dropped[k] = true
+				delete(g.values, k)
+				newlyDropped = append(newlyDropped, k)
+			}
+		}
+		labels := make(map[string]string, len(a.Labels))
+		for k, v := range a.Labels {
+			if !g.dropped[k] {
+				labels[k] = v
+			}
+		}
+		a.Labels = labels
+		filtered[i] = a
+	}
+	return filtered, newlyDropped
+}
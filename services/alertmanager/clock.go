@@ -0,0 +1,49 @@
+package alertmanager
+
+import "time"
+
+// clock abstracts the passage of time so that background work can be driven by tests.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+	AfterFunc(d time.Duration, f func()) timer
+	After(d time.Duration) <-chan time.Time
+}
+
+// timer mirrors the parts of time.Timer used by the service.
+type timer interface {
+	Stop() bool
+}
+
+// ticker mirrors the parts of time.Ticker used by the service.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// wallClock is a clock backed by the time package.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) NewTicker(d time.Duration) ticker {
+	return wallTicker{time.NewTicker(d)}
+}
+
+func (wallClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
+
+func (wallClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type wallTicker struct {
+	*time.Ticker
+}
+
+func (t wallTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -1,13 +1,37 @@
 package alertmanager
 
-import "errors"
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/toml"
+	"github.com/influxdata/kapacitor/alert"
+)
 
 // Config declares the needed configuration options for the service alertmanager.
 type Config struct {
 	// Enabled indicates whether the service should be enabled.
 	Enabled bool `toml:"enabled" override:"enabled"`
+	// Drop events without logging an error while the service is disabled,
+	// for tasks that stay attached to the handler while alerting is turned off.
+	SilentWhenDisabled bool `toml:"silent-when-disabled" override:"silent-when-disabled"`
+	// Build and log alerts as usual without sending them, for validating a configuration in production.
+	// Shadowed requests are counted in the "shadow_requests" statistic.
+	ShadowMode bool `toml:"shadow-mode" override:"shadow-mode"`
+	// Label set to "true" on alerts sent by Test or in shadow mode, so that routing can drop them.
+	// Empty disables the label.
+	TestLabel string `toml:"test-label" override:"test-label"`
 	// URL of the alertmanager server.
 	URL string `toml:"url" override:"url"`
+	// Level of alerts sent by Service.Alert without a level, "critical" unless set.
+	// It applies when the level is not a valid alert.Level, handled events always carry a level.
+	DefaultLevel string `toml:"default-level" override:"default-level"`
 	// tag name for alert in alertmanager
 	AlertManagerTagName []string `toml:"alertManagerTagName" override:"alertManagerTagName"`
 	// tag value of alertmanager
@@ -16,24 +40,878 @@ type Config struct {
 	AlertManagerAnnotationName []string `toml:"alertManagerAnnotationName" override:"alertManagerAnnotationName"`
 	// annotation value for alert in alertmanager
 	AlertManagerAnnotationValue []string `toml:"alertManagerAnnotationName" override:"alertManagerAnnotationName"`
+	// Additional query parameters merged into the URL on every request.
+	// Parameters already present on the URL are preserved unless overridden here.
+	QueryParams map[string]string `toml:"query-params" override:"query-params"`
+	// Response status codes treated as a successful delivery.
+	// When empty any 2xx status code is a success.
+	SuccessStatusCodes []int `toml:"success-status-codes" override:"success-status-codes"`
+	// Set a "measurement" label to the name of the measurement that triggered the alert.
+	IncludeMeasurement bool `toml:"include-measurement" override:"include-measurement"`
+	// Timeout for a single request to alertmanager. Zero means no timeout.
+	Timeout toml.Duration `toml:"timeout" override:"timeout"`
+	// Maximum time spent sending to a single endpoint, including retries, so that a slow endpoint
+	// fails fast and the remaining endpoints are still sent to. Zero means no limit.
+	EndpointTimeout toml.Duration `toml:"endpoint-timeout" override:"endpoint-timeout"`
+	// Only use HTTP/1.1, for proxies in front of Alertmanager that mishandle HTTP/2.
+	ForceHTTP1 bool `toml:"force-http1" override:"force-http1"`
+	// Alerts identical to one already sent within this interval are not sent again.
+	// Zero disables deduplication.
+	DedupInterval toml.Duration `toml:"dedup-interval" override:"dedup-interval"`
+	// Labels that form the deduplication key. When empty all labels are used.
+	DedupLabels []string `toml:"dedup-labels" override:"dedup-labels"`
+	// Save the deduplication state when the service closes and restore it when it opens,
+	// so that a restart does not send the alerts deduplicated before it again.
+	PersistDedup bool `toml:"persist-dedup" override:"persist-dedup"`
+	// Maximum number of saved deduplication entries, the most recently sent are kept.
+	// Entries older than DedupInterval are never saved.
+	DedupStoreSize int `toml:"dedup-store-size" override:"dedup-store-size"`
+	// Receiver selects a preset of defaults for a known Alertmanager-compatible receiver.
+	// One of "alertmanager", "cortex" or "oncall". Empty applies no preset.
+	Receiver string `toml:"receiver" override:"receiver"`
+	// Labels set on alerts sent by Alert when they are missing, so that an alert sent without
+	// any tags is still valid: "alertname" is set to "kapacitor" and "severity" to the lower cased level.
+	// Empty sets no labels.
+	// Handler alerts are left as they are, a severity taken from the level would differ between an alert
+	// and its resolve, which Alertmanager would then treat as different alerts.
+	RequiredLabels []string `toml:"required-labels" override:"required-labels"`
+	// Headers added to every request, taking precedence over any receiver preset headers.
+	Headers map[string]string `toml:"headers" override:"headers"`
+	// Window over which the max and average label and annotation counts are reported.
+	CardinalityWindow toml.Duration `toml:"cardinality-window" override:"cardinality-window"`
+	// Maximum number of distinct values of a label within LabelCardinalityWindow,
+	// labels exceeding it are no longer forwarded until the window ends. Zero disables the guard.
+	MaxLabelCardinality int `toml:"max-label-cardinality" override:"max-label-cardinality"`
+	// Window over which distinct label values are counted.
+	LabelCardinalityWindow toml.Duration `toml:"label-cardinality-window" override:"label-cardinality-window"`
+	// HTTP method used to send alerts, one of "POST" or "PUT".
+	HTTPMethod string `toml:"http-method" override:"http-method"`
+	// Append a key derived from the dedup labels to the URL path when sending with PUT,
+	// so receivers can replace the alert idempotently.
+	AppendKeyToPath bool `toml:"append-key-to-path" override:"append-key-to-path"`
+	// Send an always-firing watchdog alert on WatchdogInterval,
+	// so Alertmanager can detect when the alerting pipeline is down.
+	Watchdog bool `toml:"watchdog" override:"watchdog"`
+	// Interval between watchdog alerts.
+	WatchdogInterval toml.Duration `toml:"watchdog-interval" override:"watchdog-interval"`
+	// Labels of the watchdog alert. The alertname defaults to "Watchdog".
+	WatchdogLabels map[string]string `toml:"watchdog-labels" override:"watchdog-labels"`
+	// Annotations added to every alert, e.g. a "runbook_base" or "team".
+	// Annotations set by the handler take precedence.
+	Annotations map[string]string `toml:"annotations" override:"annotations"`
+	// Embed the JSON encoded alert event in a "kapacitor_event" annotation for debugging.
+	IncludeRawEvent bool `toml:"include-raw-event" override:"include-raw-event"`
+	// Maximum size of the "kapacitor_event" annotation, larger events are trimmed.
+	RawEventMaxBytes int `toml:"raw-event-max-bytes" override:"raw-event-max-bytes"`
+	// Delay before the first retry of a failed send, doubling on each attempt.
+	// Zero disables retries.
+	RetryInitialInterval toml.Duration `toml:"retry-initial-interval" override:"retry-initial-interval"`
+	// Maximum delay between retries.
+	RetryMaxInterval toml.Duration `toml:"retry-max-interval" override:"retry-max-interval"`
+	// Maximum total time spent retrying a send before giving up.
+	RetryMaxElapsed toml.Duration `toml:"retry-max-elapsed" override:"retry-max-elapsed"`
+	// Retry sends that timed out. Disable for receivers that are not idempotent,
+	// as the request may have been received. Refused connections are always retried.
+	RetryOnTimeout bool `toml:"retry-on-timeout" override:"retry-on-timeout"`
+	// Response status codes that are retried, other codes fail immediately.
+	// When empty no status code is retried.
+	RetryStatusCodes []int `toml:"retry-status-codes" override:"retry-status-codes"`
+	// URLs keyed by alert level name that override URL for alerts of that level,
+	// e.g. routing critical alerts to a paging alertmanager.
+	LevelURLs map[string]string `toml:"level-urls" override:"level-urls"`
+	// Labels added to every alert.
+	Labels map[string]string `toml:"labels" override:"labels"`
+	// Labels added to alerts by alert level name, e.g. a "pager" label for critical alerts.
+	LevelLabels map[string]map[string]string `toml:"level-labels" override:"level-labels"`
+	// Order in which labels are merged, lowest precedence first. A label set by a later source
+	// replaces the same label from an earlier one. The sources are "instance" for Labels,
+	// "handler" for the labels of the handler and "level" for LevelLabels.
+	// Labels generated by the service never replace labels from these sources.
+	LabelPrecedence []string `toml:"label-precedence" override:"label-precedence"`
+	// Name of the label whose value looks up the labels added from Enrichment.
+	EnrichmentLabel string `toml:"enrichment-label" override:"enrichment-label"`
+	// Labels added to handler alerts, keyed by the value of EnrichmentLabel,
+	// e.g. a "payments" service mapped to {"team": "fintech"}. Labels already set are kept.
+	Enrichment map[string]map[string]string `toml:"enrichment" override:"enrichment"`
+	// Additional URLs alerts are sent to, after URL or the level URL, for redundancy.
+	// Entries repeating URL or an earlier entry are ignored with a warning.
+	URLs []string `toml:"urls" override:"urls"`
+	// URL alerts are sent to in place of an endpoint whose host cannot be resolved,
+	// e.g. a static backup Alertmanager for when the DNS name of URL fails.
+	FallbackURL string `toml:"fallback-url" override:"fallback-url"`
+	// Credentials keyed by endpoint URL, for endpoints of URL, URLs and LevelURLs that require different authentication.
+	EndpointAuth map[string]EndpointAuth `toml:"endpoint-auth" override:"endpoint-auth,redact"`
+	// DNS SRV record, e.g. _alertmanager._tcp.example.com, resolved to the Alertmanager hosts.
+	// Alerts for URL are sent to each target in place of the host of URL, keeping its scheme and path.
+	// URL is used until the record has been resolved.
+	SRVRecord string `toml:"srv-record" override:"srv-record"`
+	// How often SRVRecord is resolved again.
+	SRVRefreshInterval toml.Duration `toml:"srv-refresh-interval" override:"srv-refresh-interval"`
+	// How alerts are sent to multiple endpoints, "fanout" sends to all of them at once,
+	// "failover" tries them in order and stops at the first success.
+	EndpointStrategy string `toml:"endpoint-strategy" override:"endpoint-strategy"`
+	// How retries interact with failover, "per-endpoint" retries each endpoint before moving to the next,
+	// "rotate" sends each attempt to the next endpoint. With fanout every endpoint is retried on its own.
+	RetryEndpointStrategy string `toml:"retry-endpoint-strategy" override:"retry-endpoint-strategy"`
+	// Number of recent sends to each endpoint used to score its health. With failover, endpoints
+	// with fewer recent failures are tried first. Zero always tries them in the configured order.
+	EndpointHealthWindow int `toml:"endpoint-health-window" override:"endpoint-health-window"`
+	// Send the name of the alert node that fired as the "kapacitor_node" annotation.
+	IncludeNode bool `toml:"include-node" override:"include-node"`
+	// Send the query of the batch task that fired as the "query" annotation.
+	IncludeQuery bool `toml:"include-query" override:"include-query"`
+	// Maximum size of the "query" annotation, longer queries are truncated.
+	QueryMaxBytes int `toml:"query-max-bytes" override:"query-max-bytes"`
+	// Send the event message as the "summary" annotation.
+	AutoSummary bool `toml:"auto-summary" override:"auto-summary"`
+	// Send the event rendered with MessageTemplate as the "message" annotation,
+	// for Alertmanager templates that render markdown.
+	MessageAnnotation bool `toml:"message-annotation" override:"message-annotation"`
+	// Template of the "message" annotation, executed with the same data as the handler templates,
+	// e.g. "**{{ .Level }}** {{ .Message }}" to bold the level.
+	MessageTemplate string `toml:"message-template" override:"message-template"`
+	// Name of the event field sent as the "value" annotation.
+	ValueField string `toml:"value-field" override:"value-field"`
+	// Send the value of ValueField from the previous event of the alert as the "previous_value" annotation.
+	IncludePreviousValue bool `toml:"include-previous-value" override:"include-previous-value"`
+	// Number of decimal places used when formatting a float ValueField.
+	ValuePrecision int `toml:"value-precision" override:"value-precision"`
+	// Name of the event field, or tag, holding a trace ID to send as the "trace_id" annotation.
+	TraceIDField string `toml:"trace-id-field" override:"trace-id-field"`
+	// Name of the label holding the alert's group identifier, e.g. "group". Empty disables the label.
+	// A label of the same name set by the handler takes precedence.
+	GroupLabel string `toml:"group-label" override:"group-label"`
+	// Labels whose values form the GroupLabel value, e.g. "service", so that related alerts share a
+	// group and Alertmanager notifies about them together. When empty the alert's group identifier is used.
+	GroupByLabels []string `toml:"group-by-labels" override:"group-by-labels"`
+	// Label matchers in the Alertmanager syntax, e.g. host="noisy01" or service=~"batch-.*",
+	// alerts whose labels match all of them are dropped instead of sent. Empty drops nothing.
+	DropSelector []string `toml:"drop-selector" override:"drop-selector"`
+	// Name of the label holding the handler's room, empty disables the label.
+	// A label of the same name set by the handler takes precedence.
+	RoomLabel string `toml:"room-label" override:"room-label"`
+	// Value of the "instance" label, used when InstanceTag is empty or the event does not have the tag.
+	Instance string `toml:"instance" override:"instance"`
+	// Name of the event tag holding the value of the "instance" label.
+	InstanceTag string `toml:"instance-tag" override:"instance-tag"`
+	// Value of the "environment" label.
+	Environment string `toml:"environment" override:"environment"`
+	// Set the "origin" label to "kapacitor".
+	IncludeOrigin bool `toml:"include-origin" override:"include-origin"`
+	// Value of the "cluster" label, distinguishing Kapacitor clusters in federated deployments.
+	// Environment variables are expanded, e.g. "${KAPACITOR_CLUSTER}".
+	Cluster string `toml:"cluster" override:"cluster"`
+	// Value of the "customer" label, used when CustomerField is empty or the event does not have the tag.
+	Customer string `toml:"customer" override:"customer"`
+	// Name of the event tag holding the value of the "customer" label, for per-customer routing and silencing.
+	CustomerField string `toml:"customer-field" override:"customer-field"`
+	// How long firing alerts are valid for, sent as their endsAt. Instead of being sent on every event,
+	// an alert still firing at the same level is only sent again to extend its endsAt when it is within
+	// EndsAtRefresh of expiring. Zero sends every event without an endsAt.
+	EndsAtWindow toml.Duration `toml:"ends-at-window" override:"ends-at-window"`
+	// How close to expiring the endsAt of a firing alert must be for it to be sent again.
+	EndsAtRefresh toml.Duration `toml:"ends-at-refresh" override:"ends-at-refresh"`
+	// How long a resolve is held back, if the alert fires again within the window
+	// neither the resolve nor the new fire is sent. Zero disables flap damping.
+	FlapWindow toml.Duration `toml:"flap-window" override:"flap-window"`
+	// Skip sending a resolve when the last status sent for the alert was already resolved.
+	SuppressDuplicateResolves bool `toml:"suppress-duplicate-resolves" override:"suppress-duplicate-resolves"`
+	// Resolve the alerts that are still firing when the service closes, e.g. on shutdown.
+	ResolveOnClose bool `toml:"resolve-on-close" override:"resolve-on-close"`
+	// Annotation set to "true" on resolves sent by the service rather than by a task, empty disables the annotation.
+	AutoResolvedAnnotation string `toml:"auto-resolved-annotation" override:"auto-resolved-annotation"`
+	// Resolves for events older than this are dropped with a warning, zero sends every resolve.
+	MaxResolveAge toml.Duration `toml:"max-resolve-age" override:"max-resolve-age"`
+	// How long the last status sent for an alert is remembered for SuppressDuplicateResolves.
+	ResolvedTTL toml.Duration `toml:"resolved-ttl" override:"resolved-ttl"`
+	// Send the change in level, "new", "escalated", "deescalated" or "resolved", as the TransitionAnnotation annotation.
+	// It is not a label as the transition changes between the events of an alert.
+	IncludeTransition bool `toml:"include-transition" override:"include-transition"`
+	// Name of the annotation holding the level transition.
+	TransitionAnnotation string `toml:"transition-annotation" override:"transition-annotation"`
+	// Send the database and retention policy the task reads from as the "database" and "rp" labels.
+	// They are set for tasks with a single database and retention policy.
+	IncludeDBRP bool `toml:"include-dbrp" override:"include-dbrp"`
+	// Send the ID of the named alert handler that produced the alert as the HandlerLabel label,
+	// for telling apart handlers sending to the same Alertmanager.
+	IncludeHandler bool `toml:"include-handler" override:"include-handler"`
+	// Name of the label holding the handler ID.
+	HandlerLabel string `toml:"handler-label" override:"handler-label"`
+	// Send the version of Kapacitor as the "kapacitor_version" label, for correlating alerts with a rollout.
+	// Every upgrade changes the label set of the alerts.
+	IncludeVersion bool `toml:"include-version" override:"include-version"`
+	// How long a critical alert must have been firing for the "urgency" annotation to be UrgencyHigh,
+	// otherwise it is UrgencyLow. Zero disables the annotation.
+	UrgencyThreshold toml.Duration `toml:"urgency-threshold" override:"urgency-threshold"`
+	// Values of the "urgency" annotation.
+	UrgencyHigh string `toml:"urgency-high" override:"urgency-high"`
+	UrgencyLow  string `toml:"urgency-low" override:"urgency-low"`
+	// Name of a numeric event field, e.g. a 1 to 5 score computed by the task, mapped to the "severity"
+	// label through SeverityThresholds. Events without the field use their lower cased level.
+	SeverityField string `toml:"severity-field" override:"severity-field"`
+	// Minimum value of SeverityField for each severity, the severity with the highest threshold
+	// not above the value is used. Values below every threshold fall back to the level.
+	SeverityThresholds map[string]float64 `toml:"severity-thresholds" override:"severity-thresholds"`
+	// Send the "severity" label as the "severity" annotation too, for routes that match the label
+	// and templates that read the annotation. Alerts without the label get their lower cased level in both.
+	SeverityAnnotation bool `toml:"severity-annotation" override:"severity-annotation"`
+	// Name of the label whose value is sent in the PartitionKeyHeader header,
+	// for gateways that partition alerts, e.g. onto Kafka partitions.
+	PartitionKeyLabel string `toml:"partition-key-label" override:"partition-key-label"`
+	// Name of the header holding the partition key.
+	PartitionKeyHeader string `toml:"partition-key-header" override:"partition-key-header"`
+	// Key used to sign request bodies with HMAC-SHA256, the hex encoded signature is sent in SignatureHeader.
+	SigningKey string `toml:"signing-key" override:"signing-key,redact"`
+	// Name of the header holding the body signature.
+	SignatureHeader string `toml:"signature-header" override:"signature-header"`
+	// JSON key of the alert status, for legacy receivers expecting e.g. "state".
+	StatusFieldName string `toml:"status-field-name" override:"status-field-name"`
+	// Format of the startsAt and endsAt timestamps, "rfc3339" for RFC3339 with nanoseconds as Alertmanager
+	// expects, or "unix" and "unixmilli" for the seconds and milliseconds since the Unix epoch.
+	TimeFormat string `toml:"time-format" override:"time-format"`
+	// Round startsAt to the nearest multiple of this duration before sending, e.g. the evaluation interval,
+	// so that repeated sends of an alert are identical for receivers that deduplicate on the payload.
+	TimeRounding toml.Duration `toml:"time-rounding" override:"time-rounding"`
+	// Encoding of the request body, "json", "form" or "webhook". "form" sends application/x-www-form-urlencoded
+	// fields named alerts[i].status, alerts[i].labels.<name> and alerts[i].annotations.<name>
+	// for legacy receivers that do not accept JSON. "webhook" wraps the alerts in the envelope
+	// Alertmanager sends to webhook receivers, with a group key computed from their common labels.
+	PayloadFormat string `toml:"payload-format" override:"payload-format"`
+	// Maximum size of a request body, zero means no limit. Oversized payloads of several alerts are split,
+	// a single alert has its largest annotations dropped until it fits.
+	MaxBodyBytes int `toml:"max-body-bytes" override:"max-body-bytes"`
+	// Maximum number of annotations of an alert, zero means no limit.
+	// The first annotations by sorted name are kept.
+	MaxAnnotations int `toml:"max-annotations" override:"max-annotations"`
+	// Maximum number of labels of an alert, zero means no limit. The "alertname" and "severity" labels
+	// are always kept, then the labels of LabelPriority in order, then the first labels by sorted name.
+	MaxLabels int `toml:"max-labels" override:"max-labels"`
+	// Labels kept ahead of the others when an alert exceeds MaxLabels.
+	LabelPriority []string `toml:"label-priority" override:"label-priority"`
+	// How often alerts that are still firing are sent again, so that Alertmanager does not resolve them.
+	// Alerts without an event for ten intervals, e.g. of a removed handler, are no longer resent.
+	// Zero disables resending.
+	ResendInterval toml.Duration `toml:"resend-interval" override:"resend-interval"`
+	// Resend intervals keyed by alert level name that override ResendInterval for alerts of that level,
+	// e.g. to resend critical alerts more often. Alerts may be resent up to the shortest interval early.
+	ResendIntervalByLevel map[string]toml.Duration `toml:"resend-interval-by-level" override:"resend-interval-by-level"`
+	// Behavior when alerts cannot be marshalled, e.g. after a Transformer set an invalid timestamp.
+	// "drop" discards them, "sanitize" removes the invalid values and sends them again.
+	OnMarshalError string `toml:"on-marshal-error" override:"on-marshal-error"`
+	// Replace newlines and other control characters in label values, for receivers that break on
+	// multi-line labels. "space" replaces each run of them with a space, "escape" writes them as
+	// escape sequences such as \n. Empty leaves label values as they are, annotations are never changed.
+	SanitizeLabelWhitespace string `toml:"sanitize-label-whitespace" override:"sanitize-label-whitespace"`
+	// Query the Alertmanager status endpoint when the service opens,
+	// logging a warning if it cannot be reached.
+	CheckOnStart bool `toml:"check-on-start" override:"check-on-start"`
+	// Log outbound requests at debug level, with secret headers redacted, for debugging routing issues.
+	LogRequests bool `toml:"log-requests" override:"log-requests"`
+	// Minimum time between logged requests.
+	LogRequestsInterval toml.Duration `toml:"log-requests-interval" override:"log-requests-interval"`
+	// Behavior when a handler fails to send an alert, one of "log", "deadletter" or "propagate".
+	OnFailure string `toml:"on-failure" override:"on-failure"`
+	// Maximum number of failed payloads kept for retry when OnFailure is "deadletter".
+	DeadLetterSize int `toml:"dead-letter-size" override:"dead-letter-size"`
+	// How often queued failed payloads are replayed.
+	DeadLetterReplayInterval toml.Duration `toml:"dead-letter-replay-interval" override:"dead-letter-replay-interval"`
+	// How long a failed payload is kept for replay before it is discarded, zero keeps it until the queue is full.
+	DeadLetterTTL toml.Duration `toml:"dead-letter-ttl" override:"dead-letter-ttl"`
+	// Probe the Alertmanager status endpoint on ReadinessInterval and buffer alerts while the
+	// most recent probe found the cluster not ready, sending them once it is ready again.
+	GateOnReadiness bool `toml:"gate-on-readiness" override:"gate-on-readiness"`
+	// How often readiness is probed.
+	ReadinessInterval toml.Duration `toml:"readiness-interval" override:"readiness-interval"`
+	// Maximum number of payloads buffered while Alertmanager is not ready, the oldest are dropped first.
+	ReadinessBufferSize int `toml:"readiness-buffer-size" override:"readiness-buffer-size"`
+	// How long handler alerts are buffered so that they are sent together, zero sends each alert on its own.
+	BatchInterval toml.Duration `toml:"batch-interval" override:"batch-interval"`
+	// Maximum number of alerts in a batch, a full batch is sent without waiting for BatchInterval.
+	BatchSize int `toml:"batch-size" override:"batch-size"`
+	// Template of the key that separates batches, only alerts with the same key are sent together,
+	// e.g. {{ .TaskName }} or {{ index .Labels "team" }}. Empty puts every alert in one batch.
+	BatchKey string `toml:"batch-key" override:"batch-key"`
+	// Send the sorted IDs of the other alerts of a request sharing the values of GroupByLabels
+	// as the comma separated "related" annotation, e.g. to show the alerts of a batch
+	// that belong to the same incident.
+	IncludeRelated bool `toml:"include-related" override:"include-related"`
+	// How long after the service opens firing alerts are held, so that the states that existed before
+	// Kapacitor started do not arrive as a burst. Held alerts are sent once the window has passed,
+	// resolves are sent immediately and discard the held alert they resolve. Held alerts are counted in the
+	// "startup_held" statistic. Zero disables the window.
+	StartupSuppressWindow toml.Duration `toml:"startup-suppress-window" override:"startup-suppress-window"`
+	// Queue handler alerts and deliver them from a background worker, so that slow
+	// Alertmanager responses do not block the task.
+	AsyncDelivery bool `toml:"async-delivery" override:"async-delivery"`
+	// Maximum number of payloads waiting for delivery, the oldest are dropped first.
+	AsyncQueueSize int `toml:"async-queue-size" override:"async-queue-size"`
+}
+
+// EndpointAuth holds the credentials sent to a single endpoint.
+type EndpointAuth struct {
+	// Username and Password are sent with HTTP basic authentication.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// Token is sent as a bearer token, in place of basic authentication.
+	Token string `toml:"token"`
 }
 
+const (
+	// OnMarshalErrorDrop discards alerts that cannot be marshalled.
+	OnMarshalErrorDrop = "drop"
+	// OnMarshalErrorSanitize removes the values that cannot be marshalled and sends the alerts again.
+	OnMarshalErrorSanitize = "sanitize"
+
+	// TimeFormatRFC3339 writes timestamps as RFC3339 with nanoseconds.
+	TimeFormatRFC3339 = "rfc3339"
+	// TimeFormatUnix writes timestamps as seconds since the Unix epoch.
+	TimeFormatUnix = "unix"
+	// TimeFormatUnixMilli writes timestamps as milliseconds since the Unix epoch.
+	TimeFormatUnixMilli = "unixmilli"
+
+	// PayloadFormatJSON sends the alerts as a JSON array.
+	PayloadFormatJSON = "json"
+	// PayloadFormatForm sends the alerts as flattened form fields.
+	PayloadFormatForm = "form"
+	// PayloadFormatWebhook sends the alerts in the Alertmanager webhook envelope.
+	PayloadFormatWebhook = "webhook"
+
+	// LabelWhitespaceSpace replaces control characters in label values with spaces.
+	LabelWhitespaceSpace = "space"
+	// LabelWhitespaceEscape replaces control characters in label values with escape sequences.
+	LabelWhitespaceEscape = "escape"
+
+	// LabelSourceInstance, LabelSourceHandler and LabelSourceLevel are the sources of LabelPrecedence.
+	LabelSourceInstance = "instance"
+	LabelSourceHandler  = "handler"
+	LabelSourceLevel    = "level"
+
+	// EndpointFanout sends alerts to every endpoint.
+	EndpointFanout = "fanout"
+	// EndpointFailover sends alerts to the first endpoint that accepts them.
+	EndpointFailover = "failover"
+
+	// RetryPerEndpoint retries an endpoint until the retries are exhausted before failing over to the next.
+	RetryPerEndpoint = "per-endpoint"
+	// RetryRotate sends each attempt to the next endpoint, waiting between rounds over the endpoints.
+	RetryRotate = "rotate"
+)
+
 func NewConfig() Config {
-	return Config{}
+	return Config{
+		CardinalityWindow:        toml.Duration(time.Minute),
+		LabelCardinalityWindow:   toml.Duration(time.Hour),
+		HTTPMethod:               http.MethodPost,
+		WatchdogInterval:         toml.Duration(time.Minute),
+		RawEventMaxBytes:         4096,
+		QueryMaxBytes:            1024,
+		RetryMaxInterval:         toml.Duration(30 * time.Second),
+		RetryMaxElapsed:          toml.Duration(5 * time.Minute),
+		RetryOnTimeout:           true,
+		RetryStatusCodes:         []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		ValuePrecision:           2,
+		RoomLabel:                defaultRoomLabel,
+		TransitionAnnotation:     defaultTransitionAnnotation,
+		HandlerLabel:             defaultHandlerLabel,
+		AutoResolvedAnnotation:   defaultAutoResolvedAnnotation,
+		UrgencyHigh:              "high",
+		UrgencyLow:               "low",
+		SeverityThresholds:       map[string]float64{"info": 1, "warning": 3, "critical": 4},
+		PartitionKeyHeader:       defaultPartitionKeyHeader,
+		EndpointStrategy:         EndpointFanout,
+		LabelPrecedence:          []string{LabelSourceInstance, LabelSourceHandler, LabelSourceLevel},
+		RetryEndpointStrategy:    RetryPerEndpoint,
+		StatusFieldName:          defaultStatusFieldName,
+		PayloadFormat:            PayloadFormatJSON,
+		TimeFormat:               TimeFormatRFC3339,
+		TestLabel:                defaultTestLabel,
+		DefaultLevel:             alert.Critical.String(),
+		SignatureHeader:          defaultSignatureHeader,
+		EndsAtRefresh:            toml.Duration(time.Minute),
+		ResolvedTTL:              toml.Duration(time.Hour),
+		LogRequestsInterval:      toml.Duration(time.Second),
+		OnMarshalError:           OnMarshalErrorDrop,
+		OnFailure:                OnFailureLog,
+		DeadLetterSize:           1000,
+		DeadLetterReplayInterval: toml.Duration(30 * time.Second),
+		DeadLetterTTL:            toml.Duration(time.Hour),
+		ReadinessInterval:        toml.Duration(10 * time.Second),
+		ReadinessBufferSize:      1000,
+		AsyncQueueSize:           1000,
+		SRVRefreshInterval:       toml.Duration(30 * time.Second),
+		BatchSize:                100,
+		DedupStoreSize:           10000,
+		MessageTemplate:          defaultMessageTemplate,
+	}
 }
 
 func (c Config) Validate() error {
 	if c.Enabled && c.URL == "" {
 		return errors.New("Must specify the alertmanager server URL")
 	}
-	if c.Enabled{
-		if len(c.AlertManagerTagName) != len(c.AlertManagerTagValue){
+	if c.Enabled {
+		if _, err := url.Parse(c.URL); err != nil {
+			return fmt.Errorf("invalid URL %q: %v", c.URL, err)
+		}
+		if c.Timeout < 0 {
+			return errors.New("timeout must not be negative")
+		}
+		if c.DefaultLevel != "" {
+			if _, err := alert.ParseLevel(c.DefaultLevel); err != nil {
+				return fmt.Errorf("invalid default-level %q: %v", c.DefaultLevel, err)
+			}
+		}
+		if c.EndpointTimeout < 0 {
+			return errors.New("endpoint-timeout must not be negative")
+		}
+		for _, u := range c.URLs {
+			if _, err := url.Parse(u); err != nil {
+				return fmt.Errorf("invalid URL %q: %v", u, err)
+			}
+		}
+		if c.FallbackURL != "" {
+			if _, err := url.Parse(c.FallbackURL); err != nil {
+				return fmt.Errorf("invalid fallback-url %q: %v", c.FallbackURL, err)
+			}
+		}
+		for u, auth := range c.EndpointAuth {
+			if _, err := url.Parse(u); err != nil {
+				return fmt.Errorf("invalid endpoint-auth URL %q: %v", u, err)
+			}
+			if auth.Token != "" && (auth.Username != "" || auth.Password != "") {
+				return fmt.Errorf("endpoint-auth for %q must not set both a token and a username or password", u)
+			}
+			if auth.Password != "" && auth.Username == "" {
+				return fmt.Errorf("endpoint-auth for %q must set a username with the password", u)
+			}
+		}
+		switch c.EndpointStrategy {
+		case "", EndpointFanout, EndpointFailover:
+		default:
+			return fmt.Errorf("invalid endpoint-strategy %q, must be %q or %q", c.EndpointStrategy, EndpointFanout, EndpointFailover)
+		}
+		switch c.RetryEndpointStrategy {
+		case "", RetryPerEndpoint, RetryRotate:
+		default:
+			return fmt.Errorf("invalid retry-endpoint-strategy %q, must be %q or %q", c.RetryEndpointStrategy, RetryPerEndpoint, RetryRotate)
+		}
+		for name, u := range c.LevelURLs {
+			if _, err := alert.ParseLevel(name); err != nil {
+				return fmt.Errorf("invalid level-urls level %q: %v", name, err)
+			}
+			if _, err := url.Parse(u); err != nil {
+				return fmt.Errorf("invalid level-urls URL %q for level %q: %v", u, name, err)
+			}
+		}
+		for name := range c.LevelLabels {
+			if _, err := alert.ParseLevel(name); err != nil {
+				return fmt.Errorf("invalid level-labels level %q: %v", name, err)
+			}
+		}
+		if len(c.Enrichment) > 0 && c.EnrichmentLabel == "" {
+			return errors.New("enrichment-label must be set when enrichment is set")
+		}
+		if n := len(c.LabelPrecedence); n > 0 && n != 3 {
+			return fmt.Errorf("label-precedence must list each of %q, %q and %q once", LabelSourceInstance, LabelSourceHandler, LabelSourceLevel)
+		}
+		if len(c.LabelPrecedence) > 0 {
+			seen := make(map[string]bool, len(c.LabelPrecedence))
+			for _, source := range c.LabelPrecedence {
+				switch source {
+				case LabelSourceInstance, LabelSourceHandler, LabelSourceLevel:
+				default:
+					return fmt.Errorf("invalid label-precedence source %q, must be %q, %q or %q", source, LabelSourceInstance, LabelSourceHandler, LabelSourceLevel)
+				}
+				if seen[source] {
+					return fmt.Errorf("label-precedence source %q listed twice", source)
+				}
+				seen[source] = true
+			}
+		}
+		if _, ok := receiverPresets[c.Receiver]; c.Receiver != "" && !ok {
+			return fmt.Errorf("unknown receiver %q", c.Receiver)
+		}
+		switch c.HTTPMethod {
+		case http.MethodPost, http.MethodPut:
+		default:
+			return fmt.Errorf("invalid http-method %q, must be one of POST or PUT", c.HTTPMethod)
+		}
+		if c.Watchdog && c.WatchdogInterval <= 0 {
+			return errors.New("watchdog-interval must be positive")
+		}
+		if c.IncludeRawEvent && c.RawEventMaxBytes <= 0 {
+			return errors.New("raw-event-max-bytes must be positive")
+		}
+		if c.RetryInitialInterval < 0 {
+			return errors.New("retry-initial-interval must not be negative")
+		}
+		if c.RetryInitialInterval > 0 {
+			if c.RetryMaxInterval < c.RetryInitialInterval {
+				return errors.New("retry-max-interval must not be less than retry-initial-interval")
+			}
+			if c.RetryMaxElapsed <= 0 {
+				return errors.New("retry-max-elapsed must be positive when retries are enabled")
+			}
+		}
+		switch c.OnMarshalError {
+		case "", OnMarshalErrorDrop, OnMarshalErrorSanitize:
+		default:
+			return fmt.Errorf("invalid on-marshal-error %q, must be %q or %q", c.OnMarshalError, OnMarshalErrorDrop, OnMarshalErrorSanitize)
+		}
+		switch c.TimeFormat {
+		case "", TimeFormatRFC3339, TimeFormatUnix, TimeFormatUnixMilli:
+		default:
+			return fmt.Errorf("invalid time-format %q, must be %q, %q or %q", c.TimeFormat, TimeFormatRFC3339, TimeFormatUnix, TimeFormatUnixMilli)
+		}
+		for _, name := range c.RequiredLabels {
+			switch name {
+			case alertNameLabel, severityLabel:
+			default:
+				return fmt.Errorf("invalid required-labels name %q, must be %q or %q", name, alertNameLabel, severityLabel)
+			}
+		}
+		if c.TimeRounding < 0 {
+			return errors.New("time-rounding must not be negative")
+		}
+		switch c.PayloadFormat {
+		case "", PayloadFormatJSON, PayloadFormatForm, PayloadFormatWebhook:
+		default:
+			return fmt.Errorf("invalid payload-format %q, must be %q, %q or %q", c.PayloadFormat, PayloadFormatJSON, PayloadFormatForm, PayloadFormatWebhook)
+		}
+		switch c.SanitizeLabelWhitespace {
+		case "", LabelWhitespaceSpace, LabelWhitespaceEscape:
+		default:
+			return fmt.Errorf("invalid sanitize-label-whitespace %q, must be %q or %q", c.SanitizeLabelWhitespace, LabelWhitespaceSpace, LabelWhitespaceEscape)
+		}
+		switch c.OnFailure {
+		case "", OnFailureLog, OnFailurePropagate:
+		case OnFailureDeadLetter:
+			if c.DeadLetterSize <= 0 {
+				return errors.New("dead-letter-size must be positive")
+			}
+			if c.DeadLetterReplayInterval <= 0 {
+				return errors.New("dead-letter-replay-interval must be positive")
+			}
+			if c.DeadLetterTTL < 0 {
+				return errors.New("dead-letter-ttl must not be negative")
+			}
+		default:
+			return fmt.Errorf("invalid on-failure %q, must be one of %q, %q or %q", c.OnFailure, OnFailureLog, OnFailureDeadLetter, OnFailurePropagate)
+		}
+		if c.UrgencyThreshold < 0 {
+			return errors.New("urgency-threshold must not be negative")
+		}
+		if c.SigningKey != "" && c.SignatureHeader == "" {
+			return errors.New("signature-header must be set when signing-key is set")
+		}
+		if c.PartitionKeyLabel != "" && c.PartitionKeyHeader == "" {
+			return errors.New("partition-key-header must be set when partition-key-label is set")
+		}
+		if c.ValuePrecision < 0 {
+			return errors.New("value-precision must not be negative")
+		}
+		if c.ResendInterval < 0 {
+			return errors.New("resend-interval must not be negative")
+		}
+		for name, d := range c.ResendIntervalByLevel {
+			if _, err := alert.ParseLevel(name); err != nil {
+				return fmt.Errorf("invalid resend-interval-by-level level %q: %v", name, err)
+			}
+			if d < 0 {
+				return fmt.Errorf("resend-interval-by-level for level %q must not be negative", name)
+			}
+		}
+		if c.LogRequestsInterval < 0 {
+			return errors.New("log-requests-interval must not be negative")
+		}
+		if c.MaxBodyBytes < 0 {
+			return errors.New("max-body-bytes must not be negative")
+		}
+		if c.GateOnReadiness && (c.ReadinessInterval <= 0 || c.ReadinessBufferSize <= 0) {
+			return errors.New("readiness-interval and readiness-buffer-size must be positive when gate-on-readiness is set")
+		}
+		if c.BatchInterval > 0 && c.BatchSize <= 0 {
+			return errors.New("batch-size must be positive when batch-interval is set")
+		}
+		if _, err := newTemplate("batch-key", c.BatchKey); err != nil {
+			return fmt.Errorf("invalid batch-key: %v", err)
+		}
+		if _, err := parseSelector(c.DropSelector); err != nil {
+			return fmt.Errorf("invalid drop-selector: %v", err)
+		}
+		if _, err := newTemplate("message-template", c.MessageTemplate); err != nil {
+			return fmt.Errorf("invalid message-template: %v", err)
+		}
+		if c.IncludeRelated && len(c.GroupByLabels) == 0 {
+			return errors.New("group-by-labels must be set when include-related is enabled")
+		}
+		if c.MaxResolveAge < 0 {
+			return errors.New("max-resolve-age must not be negative")
+		}
+		if c.StartupSuppressWindow < 0 {
+			return errors.New("startup-suppress-window must not be negative")
+		}
+		if c.SRVRecord != "" && c.SRVRefreshInterval <= 0 {
+			return errors.New("srv-refresh-interval must be positive when srv-record is set")
+		}
+		if c.AsyncDelivery && c.AsyncQueueSize <= 0 {
+			return errors.New("async-queue-size must be positive when async-delivery is set")
+		}
+		if c.IncludeQuery && c.QueryMaxBytes <= 0 {
+			return errors.New("query-max-bytes must be positive when include-query is set")
+		}
+		if c.MaxAnnotations < 0 {
+			return errors.New("max-annotations must not be negative")
+		}
+		if c.MaxLabels < 0 {
+			return errors.New("max-labels must not be negative")
+		}
+		if c.EndsAtWindow < 0 {
+			return errors.New("ends-at-window must not be negative")
+		}
+		if c.EndsAtWindow > 0 && (c.EndsAtRefresh <= 0 || c.EndsAtRefresh >= c.EndsAtWindow) {
+			return errors.New("ends-at-refresh must be positive and less than ends-at-window")
+		}
+		if c.EndpointHealthWindow < 0 {
+			return errors.New("endpoint-health-window must not be negative")
+		}
+		if c.FlapWindow < 0 {
+			return errors.New("flap-window must not be negative")
+		}
+		if c.SuppressDuplicateResolves && c.ResolvedTTL <= 0 {
+			return errors.New("resolved-ttl must be positive when suppress-duplicate-resolves is set")
+		}
+		if c.MaxLabelCardinality < 0 {
+			return errors.New("max-label-cardinality must not be negative")
+		}
+		if c.MaxLabelCardinality > 0 && c.LabelCardinalityWindow <= 0 {
+			return errors.New("label-cardinality-window must be positive when max-label-cardinality is set")
+		}
+		if c.CardinalityWindow < 0 {
+			return errors.New("cardinality-window must not be negative")
+		}
+		if c.PersistDedup && c.DedupStoreSize <= 0 {
+			return errors.New("dedup-store-size must be positive when persist-dedup is enabled")
+		}
+		if c.DedupInterval < 0 {
+			return errors.New("dedup-interval must not be negative")
+		}
+		for _, code := range c.SuccessStatusCodes {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("invalid success status code %d", code)
+			}
+		}
+		for _, code := range c.RetryStatusCodes {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("invalid retry status code %d", code)
+			}
+		}
+		if len(c.AlertManagerTagName) != len(c.AlertManagerTagValue) {
 			return errors.New("Length of tag name must equal with tag value")
 		}
-		if len(c.AlertManagerAnnotationName) != len(c.AlertManagerAnnotationValue){
+		if len(c.AlertManagerAnnotationName) != len(c.AlertManagerAnnotationValue) {
 			return errors.New("Length of annotaion name must equal with annotaion value")
 		}
 	}
 
 	return nil
 }
+
+// levelURL returns the base URL for alerts of the given level,
+// falling back to URL if the level is not present in LevelURLs.
+func (c Config) levelURL(l alert.Level) string {
+	for name, u := range c.LevelURLs {
+		if strings.EqualFold(name, l.String()) {
+			return u
+		}
+	}
+	return c.URL
+}
+
+// resendInterval returns the resend interval for alerts of the given level,
+// falling back to ResendInterval if the level is not present in ResendIntervalByLevel.
+func (c Config) resendInterval(l alert.Level) time.Duration {
+	for name, d := range c.ResendIntervalByLevel {
+		if strings.EqualFold(name, l.String()) {
+			return time.Duration(d)
+		}
+	}
+	return time.Duration(c.ResendInterval)
+}
+
+// resendTick returns how often firing alerts are checked for resending,
+// the shortest resend interval of any level, or zero if resending is disabled.
+func (c Config) resendTick() time.Duration {
+	tick := time.Duration(c.ResendInterval)
+	for _, d := range c.ResendIntervalByLevel {
+		if d := time.Duration(d); d > 0 && (tick <= 0 || d < tick) {
+			tick = d
+		}
+	}
+	return tick
+}
+
+// defaultLevel returns the parsed DefaultLevel, or critical if it is not set.
+func (c Config) defaultLevel() alert.Level {
+	if l, err := alert.ParseLevel(c.DefaultLevel); err == nil {
+		return l
+	}
+	return alert.Critical
+}
+
+// mergeLabels returns the instance, handler and level labels merged in LabelPrecedence order.
+func (c Config) mergeLabels(handler map[string]string, l alert.Level) map[string]string {
+	var level map[string]string
+	for name, labels := range c.LevelLabels {
+		if strings.EqualFold(name, l.String()) {
+			level = labels
+		}
+	}
+	sources := map[string]map[string]string{
+		LabelSourceInstance: c.Labels,
+		LabelSourceHandler:  handler,
+		LabelSourceLevel:    level,
+	}
+	precedence := c.LabelPrecedence
+	if len(precedence) == 0 {
+		precedence = []string{LabelSourceInstance, LabelSourceHandler, LabelSourceLevel}
+	}
+	merged := make(map[string]string, len(handler)+len(c.Labels)+len(level))
+	for _, source := range precedence {
+		for k, v := range sources[source] {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// endpointHeader returns the request headers for the endpoint base, with its EndpointAuth credentials if any.
+func (c Config) endpointHeader(header http.Header, base string) http.Header {
+	auth, ok := c.EndpointAuth[base]
+	if !ok {
+		return header
+	}
+	header = header.Clone()
+	switch {
+	case auth.Token != "":
+		header.Set("Authorization", "Bearer "+auth.Token)
+	case auth.Username != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)))
+	}
+	return header
+}
+
+// withoutDuplicateURLs returns the config with entries of URLs that repeat URL or an earlier entry removed,
+// and the removed URLs.
+func (c Config) withoutDuplicateURLs() (Config, []string) {
+	seen := map[string]bool{c.URL: true}
+	var urls, dups []string
+	for _, u := range c.URLs {
+		if seen[u] {
+			dups = append(dups, u)
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	if len(dups) > 0 {
+		c.URLs = urls
+	}
+	return c, dups
+}
+
+// endpoints returns the base URLs a group of alerts for base is sent to, in order.
+func (c Config) endpoints(base string) []string {
+	endpoints := []string{base}
+	for _, u := range c.URLs {
+		if u != base {
+			endpoints = append(endpoints, u)
+		}
+	}
+	return endpoints
+}
+
+// requestURL returns the base URL with QueryParams merged into its query string.
+// Any receiver preset path is applied first and a non-empty key is appended as a final path segment.
+func (c Config) requestURL(base, key string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", base, err)
+	}
+	if p, ok := receiverPresets[c.Receiver]; ok {
+		p.applyPath(u)
+	}
+	if key != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	if len(c.QueryParams) > 0 {
+		q := u.Query()
+		for k, v := range c.QueryParams {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// tagOrDefault returns the value of the event tag, or def when tag is empty or the event does not have the tag.
+func tagOrDefault(event alert.Event, tag, def string) string {
+	if tag != "" {
+		if v := event.Data.Tags[tag]; v != "" {
+			return v
+		}
+	}
+	return def
+}
+
+// urgency returns the value of the urgency annotation for the event, or an empty string if it is disabled.
+func (c Config) urgency(event alert.Event) string {
+	if c.UrgencyThreshold <= 0 {
+		return ""
+	}
+	if event.State.Level == alert.Critical && event.State.Duration >= time.Duration(c.UrgencyThreshold) {
+		return c.UrgencyHigh
+	}
+	return c.UrgencyLow
+}
+
+// severity returns the value of the "severity" label for the event, or an empty string if SeverityField is not set.
+func (c Config) severity(event alert.Event) string {
+	if c.SeverityField == "" {
+		return ""
+	}
+	if v, ok := numericField(event, c.SeverityField); ok {
+		var severity string
+		var max float64
+		for name, threshold := range c.SeverityThresholds {
+			if v >= threshold && (severity == "" || threshold > max || threshold == max && name < severity) {
+				severity, max = name, threshold
+			}
+		}
+		if severity != "" {
+			return severity
+		}
+	}
+	return strings.ToLower(event.State.Level.String())
+}
+
+// endpointContext returns the context for sending to a single endpoint, bounded by EndpointTimeout.
+func (c Config) endpointContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.EndpointTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(c.EndpointTimeout))
+}
+
+// isSuccess reports whether the response status code indicates a successful delivery.
+func (c Config) isSuccess(code int) bool {
+	if len(c.SuccessStatusCodes) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, sc := range c.SuccessStatusCodes {
+		if sc == code {
+			return true
+		}
+	}
+	return false
+}
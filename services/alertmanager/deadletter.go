@@ -0,0 +1,134 @@
+package alertmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	statDeadLettersDropped = "dead_letters_dropped"
+	statDeadLettersExpired = "dead_letters_expired"
+)
+
+// deadLetter is a payload that failed delivery.
+type deadLetter struct {
+	Alerts PostAlertManager
+	Queued time.Time
+	// h is the handler of alerts buffered until Alertmanager is ready, their outcome is recorded by it.
+	h *handler
+}
+
+// deadLetterQueue is a bounded in-memory queue of failed payloads, oldest first.
+type deadLetterQueue struct {
+	mu    sync.Mutex
+	items []deadLetter
+}
+
+// push appends the payloads to the queue, dropping the oldest entries to stay within size.
+// It returns the number of dropped entries.
+func (q *deadLetterQueue) push(size int, dls ...deadLetter) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, dls...)
+	return q.trim(size)
+}
+
+// requeue puts the payloads back at the front of the queue, ahead of any queued since they were taken.
+// It returns the number of dropped entries.
+func (q *deadLetterQueue) requeue(size int, dls []deadLetter) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(dls[:len(dls):len(dls)], q.items...)
+	return q.trim(size)
+}
+
+// trim drops the oldest entries beyond size. The caller must hold q.mu.
+func (q *deadLetterQueue) trim(size int) int {
+	over := len(q.items) - size
+	if over <= 0 {
+		return 0
+	}
+	q.items = append(q.items[:0:0], q.items[over:]...)
+	return over
+}
+
+// take removes and returns all queued payloads.
+func (q *deadLetterQueue) take() []deadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
+
+// Len returns the number of queued payloads.
+func (q *deadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// queueDeadLetter stores a failed payload for replay.
+func (s *Service) queueDeadLetter(c Config, alerts PostAlertManager) {
+	dropped := s.deadLetters.push(c.DeadLetterSize, deadLetter{
+		Alerts: alerts,
+		Queued: s.clock.Now(),
+	})
+	if dropped > 0 {
+		s.statMap.Add(statDeadLettersDropped, int64(dropped))
+	}
+}
+
+// replayDeadLetters resends queued payloads in order, discarding those older than the TTL.
+// Replay stops at the first failure and the remaining payloads are requeued.
+func (s *Service) replayDeadLetters(ctx context.Context, c Config) {
+	items := s.deadLetters.take()
+	now := s.clock.Now()
+	ttl := time.Duration(c.DeadLetterTTL)
+	for i, dl := range items {
+		if ttl > 0 && now.Sub(dl.Queued) > ttl {
+			s.statMap.Add(statDeadLettersExpired, 1)
+			continue
+		}
+		if err := s.post(ctx, dl.Alerts, sendOptions{}); err != nil {
+			s.diag.Error("failed to replay dead letter", err)
+			if dropped := s.deadLetters.requeue(c.DeadLetterSize, items[i:]); dropped > 0 {
+				s.statMap.Add(statDeadLettersDropped, int64(dropped))
+			}
+			return
+		}
+	}
+}
+
+// startReplay starts replaying dead letters on the configured interval until stopReplay is called.
+// The caller must hold s.mu.
+func (s *Service) startReplay(c Config) {
+	if !c.Enabled || c.OnFailure != OnFailureDeadLetter {
+		return
+	}
+	t := s.clock.NewTicker(time.Duration(c.DeadLetterReplayInterval))
+	stop := make(chan struct{})
+	s.replayStop = stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				s.replayDeadLetters(context.Background(), s.config())
+			}
+		}
+	}()
+}
+
+// stopReplay stops any running replay. The caller must hold s.mu.
+func (s *Service) stopReplay() {
+	if s.replayStop != nil {
+		close(s.replayStop)
+		s.replayStop = nil
+	}
+}
@@ -0,0 +1,177 @@
+package alertmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/influxdata/kapacitor/services/storage"
+)
+
+const (
+	// dedupNamespace is the storage namespace of the persisted dedup state.
+	dedupNamespace = "alertmanager_dedup"
+	// dedupStateKey is the key of the dedup entries in dedupNamespace.
+	dedupStateKey = "entries"
+)
+
+// dedupCache remembers the last status sent for each dedup key so that
+// identical alerts are not sent repeatedly.
+type dedupCache struct {
+	mu      sync.Mutex
+	entries map[string]dedupEntry
+	// pruned is when expired entries were last removed.
+	pruned time.Time
+}
+
+type dedupEntry struct {
+	Status string    `json:"status"`
+	Sent   time.Time `json:"sent"`
+}
+
+func newDedupCache() *dedupCache {
+	return &dedupCache{
+		entries: make(map[string]dedupEntry),
+	}
+}
+
+// isDuplicate reports whether an alert with the same key and status was sent within interval of now.
+func (d *dedupCache) isDuplicate(key, status string, now time.Time, interval time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	return ok && e.Status == status && now.Sub(e.Sent) < interval
+}
+
+// record stores that an alert with key and status was sent at now.
+// Entries sent more than interval ago are removed at most once per interval.
+func (d *dedupCache) record(key, status string, now time.Time, interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.pruned) >= interval {
+		for k, e := range d.entries {
+			if now.Sub(e.Sent) >= interval {
+				delete(d.entries, k)
+			}
+		}
+		d.pruned = now
+	}
+	d.entries[key] = dedupEntry{
+		Status: status,
+		Sent:   now,
+	}
+}
+
+// snapshot returns the entries sent within interval of now, at most max of the most recent ones.
+func (d *dedupCache) snapshot(now time.Time, interval time.Duration, max int) map[string]dedupEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, 0, len(d.entries))
+	for k, e := range d.entries {
+		if now.Sub(e.Sent) < interval {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := d.entries[keys[i]].Sent, d.entries[keys[j]].Sent
+		return a.After(b) || a.Equal(b) && keys[i] < keys[j]
+	})
+	if len(keys) > max {
+		keys = keys[:max]
+	}
+	entries := make(map[string]dedupEntry, len(keys))
+	for _, k := range keys {
+		entries[k] = d.entries[k]
+	}
+	return entries
+}
+
+// restore adds the entries sent within interval of now, keeping entries recorded since they were saved.
+func (d *dedupCache) restore(entries map[string]dedupEntry, now time.Time, interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, e := range entries {
+		if now.Sub(e.Sent) >= interval {
+			continue
+		}
+		if cur, ok := d.entries[k]; !ok || cur.Sent.Before(e.Sent) {
+			d.entries[k] = e
+		}
+	}
+}
+
+// persistDedup reports whether the dedup state of the config is saved to the storage service.
+func (s *Service) persistDedup(c Config) bool {
+	return c.Enabled && c.PersistDedup && c.DedupInterval > 0 && s.StorageService != nil
+}
+
+// loadDedup restores the dedup state saved by saveDedup, so that alerts sent before a restart are not sent again.
+func (s *Service) loadDedup(c Config) {
+	var entries map[string]dedupEntry
+	err := s.StorageService.Store(dedupNamespace).View(func(tx storage.ReadOnlyTx) error {
+		kv, err := tx.Get(dedupStateKey)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(kv.Value, &entries)
+	})
+	if err == storage.ErrNoKeyExists {
+		return
+	}
+	if err != nil {
+		s.diag.Error("failed to load dedup state", err)
+		return
+	}
+	s.dedup.restore(entries, s.clock.Now(), time.Duration(c.DedupInterval))
+}
+
+// saveDedup stores the most recent DedupStoreSize entries that have not expired.
+func (s *Service) saveDedup(c Config) {
+	data, err := json.Marshal(s.dedup.snapshot(s.clock.Now(), time.Duration(c.DedupInterval), c.DedupStoreSize))
+	if err == nil {
+		err = s.StorageService.Store(dedupNamespace).Update(func(tx storage.Tx) error {
+			return tx.Put(dedupStateKey, data)
+		})
+	}
+	if err != nil {
+		s.diag.Error("failed to save dedup state", err)
+	}
+}
+
+// dedupKey builds a key from the named labels, or from all labels if names is empty.
+// Missing labels contribute an empty value so the key stays stable.
+func dedupKey(labels map[string]string, names []string) string {
+	if len(names) == 0 {
+		names = make([]string, 0, len(labels))
+		for k := range labels {
+			names = append(names, k)
+		}
+	} else {
+		names = append([]string(nil), names...)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, k := range names {
+		buf.WriteString(strconv.Quote(k))
+		buf.WriteByte('=')
+		buf.WriteString(strconv.Quote(labels[k]))
+		buf.WriteByte(',')
+	}
+	return buf.String()
+}
+
+// payloadKey returns a URL path safe key identifying the alerts by their dedup keys.
+func payloadKey(alerts PostAlertManager, names []string) string {
+	h := sha256.New()
+	for _, a := range alerts {
+		h.Write([]byte(dedupKey(a.Labels, names)))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,118 @@
+package alertmanager
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+// srvLookup resolves DNS SRV records, it matches net.Resolver.LookupSRV.
+type srvLookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// discoveredTargets are the endpoints resolved from the SRV record of a config.
+type discoveredTargets struct {
+	Record    string
+	URL       string
+	Endpoints []string
+}
+
+// targetURLs returns base with its host replaced by each of the SRV targets.
+func targetURLs(base string, srvs []*net.SRV) ([]string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(srvs))
+	for i, srv := range srvs {
+		t := *u
+		t.Host = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		urls[i] = t.String()
+	}
+	return urls, nil
+}
+
+// refreshTargets resolves the SRV record of the config, keeping the previous targets if the lookup fails.
+func (s *Service) refreshTargets(ctx context.Context, c Config) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	_, srvs, err := s.lookupSRV(ctx, "", "", c.SRVRecord)
+	if err == nil && len(srvs) == 0 {
+		err = &net.DNSError{Err: "no targets", Name: c.SRVRecord}
+	}
+	var endpoints []string
+	if err == nil {
+		endpoints, err = targetURLs(c.URL, srvs)
+	}
+	if err != nil {
+		s.diag.Warn("failed to resolve alertmanager SRV record", keyvalue.KV("record", c.SRVRecord), keyvalue.KV("error", err.Error()))
+		return
+	}
+	s.targets.Store(discoveredTargets{
+		Record:    c.SRVRecord,
+		URL:       c.URL,
+		Endpoints: endpoints,
+	})
+}
+
+// endpoints returns the base URLs a group of alerts for base is sent to, in order.
+// Alerts for URL go to the targets resolved from SRVRecord once they are known.
+func (s *Service) endpoints(c Config, base string) []string {
+	endpoints := c.endpoints(base)
+	if c.SRVRecord == "" || base != c.URL {
+		return endpoints
+	}
+	t, _ := s.targets.Load().(discoveredTargets)
+	if t.Record != c.SRVRecord || t.URL != c.URL || len(t.Endpoints) == 0 {
+		return endpoints
+	}
+	seen := make(map[string]bool, len(t.Endpoints))
+	discovered := make([]string, 0, len(t.Endpoints)+len(endpoints)-1)
+	for _, e := range t.Endpoints {
+		seen[e] = true
+		discovered = append(discovered, e)
+	}
+	for _, e := range endpoints[1:] {
+		if !seen[e] {
+			discovered = append(discovered, e)
+		}
+	}
+	return discovered
+}
+
+// startDiscovery resolves the SRV record, immediately and then on the configured interval,
+// until stopDiscovery is called. The caller must hold s.mu.
+func (s *Service) startDiscovery(c Config) {
+	if !c.Enabled || c.SRVRecord == "" {
+		return
+	}
+	t := s.clock.NewTicker(time.Duration(c.SRVRefreshInterval))
+	stop := make(chan struct{})
+	s.discoveryStop = stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer t.Stop()
+		s.refreshTargets(context.Background(), c)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				s.refreshTargets(context.Background(), s.config())
+			}
+		}
+	}()
+}
+
+// stopDiscovery stops any running SRV resolution. The caller must hold s.mu.
+func (s *Service) stopDiscovery() {
+	if s.discoveryStop != nil {
+		close(s.discoveryStop)
+		s.discoveryStop = nil
+	}
+}
@@ -0,0 +1,321 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/influxdata/kapacitor/alert"
+)
+
+const (
+	// rawEventAnnotation holds the JSON encoded event when IncludeRawEvent is enabled.
+	rawEventAnnotation = "kapacitor_event"
+	// traceIDAnnotation holds the trace ID read from TraceIDField.
+	traceIDAnnotation = "trace_id"
+	// summaryAnnotation holds the event message when AutoSummary is enabled.
+	summaryAnnotation = "summary"
+	// nodeAnnotation holds the name of the alert node that created the handler when IncludeNode is enabled.
+	nodeAnnotation = "kapacitor_node"
+	// valueAnnotation holds the value of ValueField.
+	valueAnnotation = "value"
+	// previousValueAnnotation holds the previous value of ValueField when IncludePreviousValue is enabled.
+	previousValueAnnotation = "previous_value"
+	// thresholdAnnotation holds the handler threshold for the level of the event.
+	thresholdAnnotation = "threshold"
+	// relatedAnnotation holds the IDs of the alerts sharing the group of the alert when IncludeRelated is enabled.
+	relatedAnnotation = "related"
+	// severityAnnotation holds the severity label when SeverityAnnotation is enabled,
+	// it is the key of AlertmanagerAnnotations.Severity.
+	severityAnnotation = "severity"
+	// messageAnnotation holds the event rendered with MessageTemplate when MessageAnnotation is enabled.
+	messageAnnotation = "message"
+	// queryAnnotation holds the query of the batch task when IncludeQuery is enabled.
+	queryAnnotation = "query"
+	// urgencyAnnotation holds the urgency set from UrgencyThreshold.
+	// It is not a label as the urgency of a firing alert changes with its duration.
+	urgencyAnnotation = "urgency"
+)
+
+// reservedAnnotations are the annotations generated by the service.
+// When a handler sets one of them its value is kept.
+var reservedAnnotations = map[string]bool{
+	rawEventAnnotation:      true,
+	traceIDAnnotation:       true,
+	summaryAnnotation:       true,
+	valueAnnotation:         true,
+	previousValueAnnotation: true,
+	thresholdAnnotation:     true,
+	relatedAnnotation:       true,
+	severityAnnotation:      true,
+	messageAnnotation:       true,
+	nodeAnnotation:          true,
+	queryAnnotation:         true,
+	urgencyAnnotation:       true,
+}
+
+// formatValue returns the string form of the named field of the event, or false if the event has no such field.
+// Floats are formatted with precision decimal places, integers without decimals,
+// booleans as true or false and a nil value as an empty string.
+func formatValue(event alert.Event, name string, precision int) (string, bool) {
+	v, ok := event.Data.Fields[name]
+	if !ok {
+		return "", false
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case float64:
+		return strconv.FormatFloat(v, 'f', precision, 64), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case int:
+		return strconv.Itoa(v), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return v, true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// numericField returns the named field of the event as a float, if it is numeric.
+func numericField(event alert.Event, name string) (float64, bool) {
+	switch v := event.Data.Fields[name].(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// Values of the transition annotation.
+const (
+	transitionNew         = "new"
+	transitionEscalated   = "escalated"
+	transitionDeescalated = "deescalated"
+	transitionResolved    = "resolved"
+)
+
+// transition describes the change from the previous to the current level of the event,
+// or returns an empty string if the level did not change.
+func transition(event alert.Event) string {
+	prev, cur := event.PreviousState().Level, event.State.Level
+	switch {
+	case cur == prev:
+		return ""
+	case cur == alert.OK:
+		return transitionResolved
+	case prev == alert.OK:
+		return transitionNew
+	case cur > prev:
+		return transitionEscalated
+	default:
+		return transitionDeescalated
+	}
+}
+
+// requiredLabels are never removed by capLabels.
+var requiredLabels = []string{alertNameLabel, severityLabel}
+
+// capLabels removes all but max labels, returning the removed names in sorted order.
+// The required labels are kept regardless of max, then the labels of priority in order,
+// then the first labels by sorted name.
+func capLabels(labels map[string]string, max int, priority []string) []string {
+	if len(labels) <= max {
+		return nil
+	}
+	keep := make(map[string]bool, max)
+	for _, name := range requiredLabels {
+		if _, ok := labels[name]; ok {
+			keep[name] = true
+		}
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range append(priority[:len(priority):len(priority)], names...) {
+		if len(keep) >= max {
+			break
+		}
+		if _, ok := labels[name]; ok {
+			keep[name] = true
+		}
+	}
+	var dropped []string
+	for _, name := range names {
+		if !keep[name] {
+			dropped = append(dropped, name)
+			delete(labels, name)
+		}
+	}
+	return dropped
+}
+
+// setSeverity sends the severity label of the alert as its severity annotation,
+// setting both to the lower cased level if the label is not set.
+// Values already set by the handler are kept.
+func setSeverity(a AlertManagerAlert, level alert.Level) {
+	severity := a.Labels[severityLabel]
+	if severity == "" {
+		severity = strings.ToLower(level.String())
+		a.Labels[severityLabel] = severity
+	}
+	setDefault(a.Annotations, severityAnnotation, severity)
+}
+
+// capAnnotations removes all but the first max annotations by sorted name, returning the removed names.
+func capAnnotations(annotations map[string]string, max int) []string {
+	if len(annotations) <= max {
+		return nil
+	}
+	names := make([]string, 0, len(annotations))
+	for name := range annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dropped := names[max:]
+	for _, name := range dropped {
+		delete(annotations, name)
+	}
+	return dropped
+}
+
+// invalidState returns why the state of the event cannot be sent, or an empty string if it can.
+// A zero state, as produced by a malformed pipeline, has neither an ID nor a time.
+func invalidState(event alert.Event) string {
+	switch {
+	case event.State.ID == "" && event.State.Time.IsZero():
+		return "missing state"
+	case event.State.Level > alert.Critical:
+		return fmt.Sprintf("unknown level %d", int(event.State.Level))
+	}
+	return ""
+}
+
+// sanitizeLabelValue replaces the control characters of a label value according to mode,
+// either LabelWhitespaceSpace or LabelWhitespaceEscape.
+func sanitizeLabelValue(v, mode string) string {
+	if strings.IndexFunc(v, unicode.IsControl) < 0 {
+		return v
+	}
+	var b strings.Builder
+	space := false
+	for _, r := range v {
+		if !unicode.IsControl(r) {
+			b.WriteRune(r)
+			space = false
+			continue
+		}
+		if mode == LabelWhitespaceSpace {
+			if !space {
+				b.WriteByte(' ')
+				space = true
+			}
+			continue
+		}
+		switch r {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+	}
+	return b.String()
+}
+
+// truncateString returns s cut to at most maxBytes, without splitting a UTF-8 encoded rune.
+func truncateString(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
+
+// setDefault sets m[k] to v unless k is already set.
+func setDefault(m map[string]string, k, v string) {
+	if _, ok := m[k]; !ok {
+		m[k] = v
+	}
+}
+
+// fieldOrTag returns the string form of the named field of the event,
+// falling back to the tag of the same name.
+func fieldOrTag(event alert.Event, name string) (string, bool) {
+	if v, ok := event.Data.Fields[name]; ok && v != nil {
+		if s, ok := v.(string); ok {
+			return s, s != ""
+		}
+		return fmt.Sprint(v), true
+	}
+	v, ok := event.Data.Tags[name]
+	return v, ok && v != ""
+}
+
+// rawEvent is the trimmed form of an alert.Event embedded as an annotation.
+type rawEvent struct {
+	Topic    string                 `json:"topic,omitempty"`
+	ID       string                 `json:"id"`
+	Message  string                 `json:"message,omitempty"`
+	Level    alert.Level            `json:"level"`
+	Time     time.Time              `json:"time"`
+	Duration time.Duration          `json:"duration"`
+	Name     string                 `json:"name,omitempty"`
+	TaskName string                 `json:"taskName,omitempty"`
+	Group    string                 `json:"group,omitempty"`
+	Tags     map[string]string      `json:"tags,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// encodeRawEvent returns the JSON encoding of the event no larger than maxBytes.
+// Message, tags and fields are dropped if needed to fit, and false is returned
+// if even the trimmed event does not fit.
+func encodeRawEvent(event alert.Event, maxBytes int) (string, bool, error) {
+	re := rawEvent{
+		Topic:    event.Topic,
+		ID:       event.State.ID,
+		Message:  event.State.Message,
+		Level:    event.State.Level,
+		Time:     event.State.Time,
+		Duration: event.State.Duration,
+		Name:     event.Data.Name,
+		TaskName: event.Data.TaskName,
+		Group:    event.Data.Group,
+		Tags:     event.Data.Tags,
+		Fields:   event.Data.Fields,
+	}
+	data, err := json.Marshal(re)
+	if err != nil {
+		return "", false, err
+	}
+	if len(data) <= maxBytes {
+		return string(data), true, nil
+	}
+	re.Message, re.Tags, re.Fields = "", nil, nil
+	data, err = json.Marshal(re)
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), len(data) <= maxBytes, nil
+}
@@ -0,0 +1,47 @@
+package alertmanager
+
+const (
+	// OnFailureLog logs failed sends and drops the alert.
+	OnFailureLog = "log"
+	// OnFailureDeadLetter queues failed sends to be replayed later.
+	OnFailureDeadLetter = "deadletter"
+	// OnFailurePropagate marks the handler as errored, counts the failure in the service statistics
+	// and reports it to the HandlerConfig.OnError callback.
+	OnFailurePropagate = "propagate"
+)
+
+const (
+	statHandlerErrors = "handler_errors"
+)
+
+// handleFailure applies the configured OnFailure behavior to a failed send.
+func (h *handler) handleFailure(c Config, alerts PostAlertManager, err error) {
+	switch c.OnFailure {
+	case OnFailureDeadLetter:
+		h.s.queueDeadLetter(c, alerts)
+		h.diag.Error("failed to handle event, queued for retry", err)
+	case OnFailurePropagate:
+		h.setErr(err)
+		h.s.statMap.Add(statHandlerErrors, 1)
+		h.diag.Error("E! failed to handle event", err)
+		if h.c.OnError != nil {
+			h.c.OnError(err)
+		}
+	default:
+		h.diag.Error("E! failed to handle event", err)
+	}
+}
+
+// Err returns the error of the most recent failed send when OnFailure is "propagate",
+// or nil if the most recent send succeeded.
+func (h *handler) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+func (h *handler) setErr(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+}
@@ -0,0 +1,76 @@
+package alertmanager
+
+import (
+	"sync"
+	"time"
+)
+
+// flapDamper holds back resolves so that alerts flapping between firing and resolved
+// within the flap window are not sent.
+type flapDamper struct {
+	mu      sync.Mutex
+	pending map[activeKey]*heldResolve
+}
+
+// heldResolve is a resolve waiting for its flap window to pass.
+type heldResolve struct {
+	t    timer
+	send func()
+}
+
+func newFlapDamper() *flapDamper {
+	return &flapDamper{
+		pending: make(map[activeKey]*heldResolve),
+	}
+}
+
+// hold calls send once window has passed, unless cancel is called for the alert first.
+// A resolve already held for the alert is replaced.
+func (f *flapDamper) hold(c clock, k activeKey, window time.Duration, send func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if h, ok := f.pending[k]; ok {
+		h.t.Stop()
+	}
+	h := &heldResolve{send: send}
+	h.t = c.AfterFunc(window, func() {
+		f.mu.Lock()
+		current := f.pending[k] == h
+		if current {
+			delete(f.pending, k)
+		}
+		f.mu.Unlock()
+		if current {
+			send()
+		}
+	})
+	f.pending[k] = h
+}
+
+// cancel discards the resolve held for the alert, reporting whether there was one.
+func (f *flapDamper) cancel(k activeKey) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.pending[k]
+	if !ok {
+		return false
+	}
+	h.t.Stop()
+	delete(f.pending, k)
+	return true
+}
+
+// flush sends all held resolves without waiting for their flap window to pass.
+func (f *flapDamper) flush() {
+	f.mu.Lock()
+	var sends []func()
+	for k, h := range f.pending {
+		h.t.Stop()
+		sends = append(sends, h.send)
+		delete(f.pending, k)
+	}
+	f.mu.Unlock()
+	for _, send := range sends {
+		send()
+	}
+}
@@ -0,0 +1,59 @@
+package alertmanager
+
+import (
+	"sort"
+	"sync"
+)
+
+// endpointHealth keeps the outcome of the most recent sends to each endpoint,
+// so that failover can try the healthiest endpoints first.
+type endpointHealth struct {
+	mu sync.Mutex
+	// results are the most recent outcomes by endpoint, oldest first, true for a failure.
+	results map[string][]bool
+}
+
+func newEndpointHealth() *endpointHealth {
+	return &endpointHealth{
+		results: make(map[string][]bool),
+	}
+}
+
+// record adds the outcome of a send to the endpoint, keeping the last window outcomes.
+func (h *endpointHealth) record(endpoint string, failed bool, window int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := append(h.results[endpoint], failed)
+	if len(r) > window {
+		r = r[len(r)-window:]
+	}
+	h.results[endpoint] = r
+}
+
+// failures returns the number of failures among the recent outcomes of the endpoint.
+// The caller must hold h.mu.
+func (h *endpointHealth) failures(endpoint string) int {
+	n := 0
+	for _, failed := range h.results[endpoint] {
+		if failed {
+			n++
+		}
+	}
+	return n
+}
+
+// order returns the endpoints sorted by their recent failures, fewest first.
+// Endpoints with as many failures keep their configured order.
+func (h *endpointHealth) order(endpoints []string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	failures := make(map[string]int, len(endpoints))
+	for _, e := range endpoints {
+		failures[e] = h.failures(e)
+	}
+	ordered := append([]string(nil), endpoints...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return failures[ordered[i]] < failures[ordered[j]]
+	})
+	return ordered
+}
@@ -0,0 +1,52 @@
+package alertmanager
+
+import (
+	"sync"
+	"time"
+)
+
+// keyedMutex serializes work per key while work for different keys proceeds in parallel.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+	// last is the time of the most recent event sent while holding the lock.
+	// It is kept while others are waiting for the lock, so that they can detect stale events.
+	last time.Time
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{
+		locks: make(map[string]*keyLock),
+	}
+}
+
+// lock acquires the lock for key, the returned lock must be released with unlock.
+func (m *keyedMutex) lock(key string) *keyLock {
+	m.mu.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = new(keyLock)
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.mu.Lock()
+	return l
+}
+
+// unlock releases the lock for key, forgetting it once no one is waiting for it.
+func (m *keyedMutex) unlock(key string, l *keyLock) {
+	l.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+}
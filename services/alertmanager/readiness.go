@@ -0,0 +1,105 @@
+package alertmanager
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+const (
+	statReadinessBuffered = "readiness_buffered"
+	statReadinessDropped  = "readiness_dropped"
+)
+
+// clusterReady reports whether a node with the cluster status accepts alerts.
+// A "disabled" cluster is a node running without clustering.
+func clusterReady(status string) bool {
+	return status == "ready" || status == "disabled"
+}
+
+// ready reports whether the most recent readiness probe found Alertmanager ready.
+// Alertmanager is considered ready until a probe says otherwise.
+func (s *Service) ready() bool {
+	return atomic.LoadInt32(&s.notReady) == 0
+}
+
+// probeReadiness queries the status endpoint, flushing the buffered alerts once Alertmanager is ready.
+// A failed probe counts as not ready.
+func (s *Service) probeReadiness(ctx context.Context, c Config) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var status AlertmanagerStatus
+	if err := s.getJSON(ctx, statusPath, nil, &status); err != nil {
+		s.diag.Warn("Alertmanager readiness probe failed", keyvalue.KV("error", err.Error()))
+		atomic.StoreInt32(&s.notReady, 1)
+		return
+	}
+	if !clusterReady(status.Cluster.Status) {
+		s.diag.Debug("Alertmanager is not ready, buffering alerts", keyvalue.KV("status", status.Cluster.Status))
+		atomic.StoreInt32(&s.notReady, 1)
+		return
+	}
+	atomic.StoreInt32(&s.notReady, 0)
+	s.flushGated(ctx, c)
+}
+
+// gate buffers the alerts of the handler until Alertmanager is ready, dropping the oldest beyond ReadinessBufferSize.
+func (s *Service) gate(c Config, h *handler, alerts PostAlertManager) {
+	s.statMap.Add(statReadinessBuffered, 1)
+	dropped := s.gated.push(c.ReadinessBufferSize, deadLetter{
+		Alerts: alerts,
+		Queued: s.clock.Now(),
+		h:      h,
+	})
+	if dropped > 0 {
+		s.statMap.Add(statReadinessDropped, int64(dropped))
+	}
+}
+
+// flushGated sends the buffered alerts in order, recording the outcome with their handler.
+func (s *Service) flushGated(ctx context.Context, c Config) {
+	for _, dl := range s.gated.take() {
+		err := s.post(ctx, dl.Alerts, sendOptions{})
+		if dl.h != nil {
+			dl.h.delivered(c, dl.Alerts, s.clock.Now(), err)
+		} else if err != nil {
+			s.diag.Error("failed to send buffered alert", err)
+		}
+	}
+}
+
+// startReadiness starts probing the readiness of Alertmanager, immediately and then on the
+// configured interval, until stopReadiness is called. The caller must hold s.mu.
+func (s *Service) startReadiness(c Config) {
+	if !c.Enabled || !c.GateOnReadiness {
+		atomic.StoreInt32(&s.notReady, 0)
+		return
+	}
+	t := s.clock.NewTicker(time.Duration(c.ReadinessInterval))
+	stop := make(chan struct{})
+	s.readinessStop = stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer t.Stop()
+		s.probeReadiness(context.Background(), c)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				s.probeReadiness(context.Background(), s.config())
+			}
+		}
+	}()
+}
+
+// stopReadiness stops any running readiness probe. The caller must hold s.mu.
+func (s *Service) stopReadiness() {
+	if s.readinessStop != nil {
+		close(s.readinessStop)
+		s.readinessStop = nil
+	}
+}
@@ -0,0 +1,78 @@
+package alertmanager
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	// defaultAlertName is used for the alertname label when the alert has no ID.
+	defaultAlertName = "kapacitor"
+
+	alertNameLabel = "alertname"
+	severityLabel  = "severity"
+)
+
+// receiverPreset captures the quirks of a known Alertmanager-compatible receiver.
+type receiverPreset struct {
+	// Path is used when the configured URL does not specify a path.
+	Path string
+	// TrailingSlash ensures the request path ends with a slash.
+	TrailingSlash bool
+	// Headers are sent with every request unless overridden by the configured headers.
+	Headers map[string]string
+	// RequiredLabels are always set, using a value derived from the alert when missing.
+	RequiredLabels []string
+}
+
+var receiverPresets = map[string]receiverPreset{
+	"alertmanager": {
+		Path:           "/api/v1/alerts",
+		RequiredLabels: []string{alertNameLabel},
+	},
+	"cortex": {
+		Path: "/alertmanager/api/v1/alerts",
+		// Cortex expects a tenant ID; "fake" is the tenant used when auth is disabled.
+		Headers:        map[string]string{"X-Scope-OrgID": "fake"},
+		RequiredLabels: []string{alertNameLabel},
+	},
+	"oncall": {
+		// Grafana OnCall integration URLs only accept requests with a trailing slash.
+		TrailingSlash:  true,
+		RequiredLabels: []string{alertNameLabel, severityLabel},
+	},
+}
+
+func (p receiverPreset) applyPath(u *url.URL) {
+	if p.Path != "" && (u.Path == "" || u.Path == "/") {
+		u.Path = p.Path
+	}
+	if p.TrailingSlash && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+}
+
+// applyLabels sets any missing required labels on the alert.
+func (p receiverPreset) applyLabels(a AlertManagerAlert) {
+	setRequiredLabels(a, p.RequiredLabels)
+}
+
+// setRequiredLabels sets the missing labels of the names with a value derived from the alert:
+// the alert ID or defaultAlertName for alertname, the lower cased level for severity.
+// Other names are left unset.
+func setRequiredLabels(a AlertManagerAlert, names []string) {
+	for _, l := range names {
+		if a.Labels[l] != "" {
+			continue
+		}
+		switch l {
+		case alertNameLabel:
+			a.Labels[l] = a.id
+			if a.id == "" {
+				a.Labels[l] = defaultAlertName
+			}
+		case severityLabel:
+			a.Labels[l] = strings.ToLower(a.level.String())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package alertmanager
+
+import (
+	"time"
+
+	"github.com/influxdata/kapacitor/alert"
+)
+
+// sentEndsAt is the endsAt and level last sent for a firing alert.
+type sentEndsAt struct {
+	EndsAt time.Time
+	Level  alert.Level
+}
+
+// refreshEndsAt sets the endsAt of the alert, reporting whether it needs to be sent.
+// A firing alert at an unchanged level is only sent again once its last endsAt is within
+// EndsAtRefresh of expiring, the resend extends endsAt by EndsAtWindow.
+// The endsAt is recorded by recordEndsAt once the alert is delivered.
+func (h *handler) refreshEndsAt(c Config, a *AlertManagerAlert) bool {
+	now := h.s.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if a.Status == statusResolved {
+		delete(h.endsAt, a.id)
+		a.EndsAt = now
+		return true
+	}
+	last, ok := h.endsAt[a.id]
+	if ok && last.Level == a.level && now.Before(last.EndsAt.Add(-time.Duration(c.EndsAtRefresh))) {
+		return false
+	}
+	a.EndsAt = now.Add(time.Duration(c.EndsAtWindow))
+	return true
+}
+
+// recordEndsAt records the endsAt of the delivered firing alerts.
+func (h *handler) recordEndsAt(postMessage PostAlertManager) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, a := range postMessage {
+		if a.id == "" || a.Status == statusResolved || a.EndsAt.IsZero() {
+			continue
+		}
+		h.endsAt[a.id] = sentEndsAt{EndsAt: a.EndsAt, Level: a.level}
+	}
+}
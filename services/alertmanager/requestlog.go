@@ -0,0 +1,117 @@
+package alertmanager
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveWords are the words that mark a header or query parameter value as secret.
+var sensitiveWords = []string{"authorization", "token", "key", "secret", "signature", "cookie", "password"}
+
+// requestLogger rate limits the logging of outbound requests.
+type requestLogger struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// allow reports whether a request may be logged, at most once per interval.
+func (l *requestLogger) allow(now time.Time, interval time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.Sub(l.last) < interval {
+		return false
+	}
+	l.last = now
+	return true
+}
+
+// logRequest logs the request at debug level with secrets redacted.
+func (s *Service) logRequest(c Config, or outboundRequest) {
+	if !s.requestLog.allow(s.clock.Now(), time.Duration(c.LogRequestsInterval)) {
+		return
+	}
+	s.diag.Debug("sending request",
+		keyvalue.KV("method", or.Method),
+		keyvalue.KV("url", redactURL(or.URL)),
+		keyvalue.KV("headers", redactHeaders(c, or.Header)),
+		keyvalue.KV("body", string(or.Body)),
+	)
+}
+
+// redactURL returns the URL with any password and the values of sensitive query parameters replaced.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	if u.RawQuery != "" {
+		query, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return redacted
+		}
+		changed := false
+		for name, values := range query {
+			if sensitiveName(name) {
+				for i := range values {
+					values[i] = redacted
+				}
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.String()
+}
+
+// redactHeaders formats the headers with the values of sensitive headers replaced.
+func redactHeaders(c Config, h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteString(": ")
+		if sensitiveHeader(c, name) {
+			b.WriteString(redacted)
+		} else {
+			b.WriteString(strings.Join(h[name], ","))
+		}
+	}
+	return b.String()
+}
+
+func sensitiveHeader(c Config, name string) bool {
+	return strings.EqualFold(name, c.SignatureHeader) || sensitiveName(name)
+}
+
+// sensitiveName reports whether the header or query parameter name contains one of sensitiveWords.
+func sensitiveName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, w := range sensitiveWords {
+		if strings.Contains(lower, w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package alertmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// activeKey identifies a firing alert sent by a handler.
+type activeKey struct {
+	h  *handler
+	id string
+}
+
+// activeExpiryIntervals is how many resend intervals a firing alert is resent for
+// without an event refreshing it, e.g. once its handler is gone.
+const activeExpiryIntervals = 10
+
+// activeAlert is a firing alert, when it was last sent and when an event last refreshed it.
+type activeAlert struct {
+	Alerts    PostAlertManager
+	Sent      time.Time
+	Refreshed time.Time
+}
+
+// activeAlerts tracks the firing alerts that are periodically resent.
+type activeAlerts struct {
+	mu     sync.Mutex
+	alerts map[activeKey]*activeAlert
+}
+
+func newActiveAlerts() *activeAlerts {
+	return &activeAlerts{
+		alerts: make(map[activeKey]*activeAlert),
+	}
+}
+
+// update records the alerts sent by the handler at now, forgetting those that resolved.
+func (a *activeAlerts) update(h *handler, alerts PostAlertManager, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, alert := range alerts {
+		k := activeKey{h: h, id: alert.id}
+		if alert.Status == statusResolved {
+			delete(a.alerts, k)
+		} else {
+			a.alerts[k] = &activeAlert{Alerts: PostAlertManager{alert}, Sent: now, Refreshed: now}
+		}
+	}
+}
+
+// due returns the payloads of the firing alerts whose resend interval has elapsed at now,
+// allowing for a tick of early, and marks them as sent.
+// Alerts not refreshed within activeExpiryIntervals resend intervals are forgotten instead.
+func (a *activeAlerts) due(c Config, now time.Time, tick time.Duration) []PostAlertManager {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var l []PostAlertManager
+	for k, aa := range a.alerts {
+		interval := c.resendInterval(aa.Alerts[0].level)
+		if interval <= 0 || now.Sub(aa.Sent) <= interval-tick {
+			continue
+		}
+		if now.Sub(aa.Refreshed) > activeExpiryIntervals*interval-tick {
+			delete(a.alerts, k)
+			continue
+		}
+		aa.Sent = now
+		l = append(l, aa.Alerts)
+	}
+	return l
+}
+
+// take removes and returns the payloads of all firing alerts.
+func (a *activeAlerts) take() []PostAlertManager {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := make([]PostAlertManager, 0, len(a.alerts))
+	for k, aa := range a.alerts {
+		l = append(l, aa.Alerts)
+		delete(a.alerts, k)
+	}
+	return l
+}
+
+// resolveActive resolves every firing alert, marking the resolves with AutoResolvedAnnotation
+// so that they can be told apart from genuine recoveries while still matching the firing alerts.
+func (s *Service) resolveActive(ctx context.Context, c Config) {
+	now := s.clock.Now()
+	for _, alerts := range s.active.take() {
+		resolved := make(PostAlertManager, len(alerts))
+		for i, a := range alerts {
+			if c.AutoResolvedAnnotation != "" {
+				annotations := make(map[string]string, len(a.Annotations)+1)
+				for k, v := range a.Annotations {
+					annotations[k] = v
+				}
+				annotations[c.AutoResolvedAnnotation] = "true"
+				a.Annotations = annotations
+			}
+			a.Status = statusResolved
+			a.EndsAt = now
+			resolved[i] = a
+		}
+		if err := s.post(ctx, resolved, sendOptions{}); err != nil {
+			s.diag.Error("failed to resolve alert", err)
+		}
+	}
+}
+
+// resendActive posts the still-firing alerts that are due again.
+func (s *Service) resendActive(ctx context.Context, c Config) {
+	for _, alerts := range s.active.due(c, s.clock.Now(), c.resendTick()) {
+		if err := s.post(ctx, alerts, sendOptions{}); err != nil {
+			s.diag.Error("failed to resend alert", err)
+		}
+	}
+}
+
+// startResend starts resending firing alerts on the shortest configured interval until stopResend is called.
+// The caller must hold s.mu.
+func (s *Service) startResend(c Config) {
+	tick := c.resendTick()
+	if !c.Enabled || tick <= 0 {
+		return
+	}
+	t := s.clock.NewTicker(tick)
+	stop := make(chan struct{})
+	s.resendStop = stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				s.resendActive(context.Background(), s.config())
+			}
+		}
+	}()
+}
+
+// stopResend stops any running resend. The caller must hold s.mu.
+func (s *Service) stopResend() {
+	if s.resendStop != nil {
+		close(s.resendStop)
+		s.resendStop = nil
+	}
+}
@@ -0,0 +1,37 @@
+package alertmanager
+
+import "time"
+
+// sentStatus is the status last sent for an alert.
+type sentStatus struct {
+	Status string
+	Sent   time.Time
+}
+
+// duplicateResolve reports whether the alert is a resolve of an alert that was
+// last sent resolved, within ResolvedTTL of now.
+func (h *handler) duplicateResolve(c Config, a AlertManagerAlert, now time.Time) bool {
+	if a.Status != statusResolved {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, ok := h.lastStatus[a.id]
+	return ok && last.Status == statusResolved && now.Sub(last.Sent) < time.Duration(c.ResolvedTTL)
+}
+
+// recordStatus remembers the status sent for each alert, forgetting statuses older than ResolvedTTL.
+func (h *handler) recordStatus(c Config, alerts PostAlertManager, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, last := range h.lastStatus {
+		if now.Sub(last.Sent) >= time.Duration(c.ResolvedTTL) {
+			delete(h.lastStatus, id)
+		}
+	}
+	for _, a := range alerts {
+		if a.id != "" {
+			h.lastStatus[a.id] = sentStatus{Status: a.Status, Sent: now}
+		}
+	}
+}
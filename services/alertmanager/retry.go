@@ -0,0 +1,104 @@
+package alertmanager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// newBackOff returns the exponential backoff policy for retrying failed sends, timed by clk.
+func newBackOff(c Config, clk clock) *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.Clock = clk
+	b.InitialInterval = time.Duration(c.RetryInitialInterval)
+	b.Multiplier = 2
+	b.MaxInterval = time.Duration(c.RetryMaxInterval)
+	b.MaxElapsedTime = time.Duration(c.RetryMaxElapsed)
+	b.Reset()
+	return b
+}
+
+// sendWithRetry sends the request, retrying retryable failures with exponential backoff
+// when retries are enabled. Once the next delay would exceed RetryMaxElapsed the last error is returned.
+func (s *Service) sendWithRetry(ctx context.Context, c Config, or outboundRequest) error {
+	err := s.send(ctx, c, or)
+	if err == nil || c.RetryInitialInterval <= 0 {
+		return err
+	}
+	b := newBackOff(c, s.clock)
+	for retryable(c, err) {
+		next := b.NextBackOff()
+		if next == backoff.Stop || b.GetElapsedTime()+next > b.MaxElapsedTime {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.clock.After(next):
+		}
+		if err = s.send(ctx, c, or); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// sendRotating sends each attempt to the next of the requests, one per endpoint, until one succeeds.
+// After every round over the endpoints it waits for the next backoff delay, without retries a single round is made.
+// Endpoints failing with an error that is not retryable are left out of later rounds, the last error is returned.
+func (s *Service) sendRotating(ctx context.Context, c Config, ors []outboundRequest) error {
+	var b *backoff.ExponentialBackOff
+	if c.RetryInitialInterval > 0 {
+		b = newBackOff(c, s.clock)
+	}
+	var err error
+	for {
+		var remaining []outboundRequest
+		for _, or := range ors {
+			ectx, cancel := c.endpointContext(ctx)
+			err = s.send(ectx, c, or)
+			cancel()
+			if err == nil {
+				return nil
+			}
+			if retryable(c, err) {
+				remaining = append(remaining, or)
+			}
+		}
+		if b == nil || len(remaining) == 0 {
+			return err
+		}
+		next := b.NextBackOff()
+		if next == backoff.Stop || b.GetElapsedTime()+next > b.MaxElapsedTime {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.clock.After(next):
+		}
+		ors = remaining
+	}
+}
+
+// retryable reports whether a failed send may succeed if attempted again.
+// Status codes are retried according to RetryStatusCodes.
+// Timeouts are only retried when RetryOnTimeout is set, since the request may have been received.
+func retryable(c Config, err error) bool {
+	var sce *statusCodeError
+	if errors.As(err, &sce) {
+		for _, code := range c.RetryStatusCodes {
+			if code == sce.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+	var cte *ClientTimeoutError
+	if errors.As(err, &cte) {
+		return c.RetryOnTimeout
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package alertmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const statSelectorDropped = "selector_dropped"
+
+// matcherPattern splits an Alertmanager label matcher, e.g. host=~"web-.*", into its name, operator and quoted value.
+var matcherPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*")\s*$`)
+
+// matcher matches the value of a label, a missing label has an empty value.
+type matcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (m matcher) matches(labels map[string]string) bool {
+	v := labels[m.name]
+	switch m.op {
+	case "=":
+		return v == m.value
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+// selector matches the alerts whose labels match all of its matchers, an empty selector matches nothing.
+type selector []matcher
+
+// parseSelector parses label matchers in the Alertmanager syntax: name="value", name!="value",
+// name=~"regexp" and name!~"regexp". Regular expressions are anchored at both ends.
+func parseSelector(matchers []string) (selector, error) {
+	sel := make(selector, 0, len(matchers))
+	for _, s := range matchers {
+		parts := matcherPattern.FindStringSubmatch(s)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid matcher %q, must be of the form name=\"value\"", s)
+		}
+		value, err := strconv.Unquote(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %v", s, err)
+		}
+		m := matcher{name: parts[1], op: parts[2], value: value}
+		if m.op == "=~" || m.op == "!~" {
+			if m.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+				return nil, fmt.Errorf("invalid matcher %q: %v", s, err)
+			}
+		}
+		sel = append(sel, m)
+	}
+	return sel, nil
+}
+
+// matches reports whether the labels match every matcher of the selector.
+func (sel selector) matches(labels map[string]string) bool {
+	if len(sel) == 0 {
+		return false
+	}
+	for _, m := range sel {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// drop removes the alerts matched by the selector, returning the remaining alerts and the number removed.
+func (sel selector) drop(alerts PostAlertManager) (PostAlertManager, int) {
+	if len(sel) == 0 {
+		return alerts, 0
+	}
+	kept := make(PostAlertManager, 0, len(alerts))
+	for _, a := range alerts {
+		if !sel.matches(a.Labels) {
+			kept = append(kept, a)
+		}
+	}
+	return kept, len(alerts) - len(kept)
+}
@@ -0,0 +1,53 @@
+package alertmanager
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/influxdata/kapacitor/alert"
+	"github.com/influxdata/kapacitor/tick/ast"
+	"github.com/influxdata/kapacitor/tick/stateful"
+)
+
+// sendIf is a compiled SendIf predicate.
+// The predicate is a TICKscript lambda expression over the fields and tags of the event,
+// e.g. `"value" > 90.0 AND "host" != 'serverA'`. References are resolved from the fields first,
+// then from the tags.
+type sendIf struct {
+	mu    sync.Mutex
+	expr  stateful.Expression
+	scope *stateful.Scope
+	vars  []string
+}
+
+func newSendIf(predicate string) (*sendIf, error) {
+	lambda, err := ast.ParseLambda(predicate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid send-if expression: %v", err)
+	}
+	expr, err := stateful.NewExpression(lambda.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid send-if expression: %v", err)
+	}
+	return &sendIf{
+		expr:  expr,
+		scope: stateful.NewScope(),
+		vars:  ast.FindReferenceVariables(lambda),
+	}, nil
+}
+
+// eval reports whether the event satisfies the predicate.
+func (p *sendIf) eval(event alert.Event) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, v := range p.vars {
+		if f, ok := event.Data.Fields[v]; ok {
+			p.scope.Set(v, f)
+		} else if tag, ok := event.Data.Tags[v]; ok {
+			p.scope.Set(v, tag)
+		} else {
+			return false, fmt.Errorf("no field or tag exists for %s", v)
+		}
+	}
+	return p.expr.EvalBool(p.scope)
+}
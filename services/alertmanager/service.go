@@ -2,25 +2,150 @@ package alertmanager
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/influxdata/kapacitor/alert"
-	"github.com/influxdata/kapacitor/keyvalue"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	text "text/template"
+	"time"
+
+	"github.com/influxdata/kapacitor/alert"
+	"github.com/influxdata/kapacitor/expvar"
+	khttp "github.com/influxdata/kapacitor/http"
+	"github.com/influxdata/kapacitor/keyvalue"
+	"github.com/influxdata/kapacitor/server/vars"
+	"github.com/influxdata/kapacitor/services/storage"
+)
+
+const (
+	statClientTimeouts = "client_timeouts"
+	statServerClosed   = "server_closed"
+	statMarshalErrors  = "marshal_errors"
+	statShadowRequests = "shadow_requests"
+	statFallbackSends  = "fallback_sends"
+)
+
+const (
+	// measurementLabel is the label set to the measurement name when IncludeMeasurement is enabled.
+	measurementLabel = "measurement"
+	// statusFiring and statusResolved are the alert statuses understood by Alertmanager.
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+	// defaultStatusFieldName is the JSON key of the alert status unless configured otherwise.
+	defaultStatusFieldName = "status"
+	// defaultWatchdogAlertName is the alertname of watchdog alerts unless configured otherwise.
+	defaultWatchdogAlertName = "Watchdog"
+	// defaultRoomLabel is the label set to the handler Room unless configured otherwise.
+	defaultRoomLabel = "channel"
+	// defaultTestLabel is the label marking test and shadow alerts unless configured otherwise.
+	defaultTestLabel = "kapacitor_test"
+	// defaultMessageTemplate renders the message annotation with the level in bold unless configured otherwise.
+	defaultMessageTemplate = "**{{ .Level }}** {{ .Message }}"
+
+	// instanceLabel, environmentLabel, originLabel and customerLabel match the fields of AlertmanagerLabels.
+	instanceLabel    = "instance"
+	environmentLabel = "environment"
+	originLabel      = "origin"
+	customerLabel    = "customer"
+	// clusterLabel is the label set from Cluster.
+	clusterLabel = "cluster"
+	// databaseLabel and retentionPolicyLabel are the labels set from the task DBRP when IncludeDBRP is enabled.
+	databaseLabel        = "database"
+	retentionPolicyLabel = "rp"
+	// versionLabel is the label set to the Kapacitor build version when IncludeVersion is enabled.
+	versionLabel = "kapacitor_version"
+	// defaultTransitionAnnotation is the annotation holding the level transition unless configured otherwise.
+	defaultTransitionAnnotation = "transition"
+	// defaultAutoResolvedAnnotation is the annotation marking resolves sent by the service unless configured otherwise.
+	defaultAutoResolvedAnnotation = "auto_resolved"
+	// defaultHandlerLabel is the label holding the handler ID unless configured otherwise.
+	defaultHandlerLabel = "handler"
+	// defaultSignatureHeader is the header holding the body signature unless configured otherwise.
+	defaultSignatureHeader = "X-Signature"
+	// defaultPartitionKeyHeader is the header holding the partition key unless configured otherwise.
+	defaultPartitionKeyHeader = "X-Partition-Key"
+	// origin is the value of the origin label when IncludeOrigin is enabled.
+	origin = "kapacitor"
 )
 
 type Diagnostic interface {
 	WithContext(ctx ...keyvalue.T) Diagnostic
 	TemplateError(err error, kv keyvalue.T)
 	Error(msg string, err error)
+	Warn(msg string, ctx ...keyvalue.T)
+	Debug(msg string, ctx ...keyvalue.T)
 }
 
 type Service struct {
-	configValue atomic.Value
-	diag        Diagnostic
+	mu     sync.Mutex
+	opened bool
+	wg     sync.WaitGroup
+
+	// settingsValue holds the *settings in use, a config and its client are swapped together.
+	settingsValue atomic.Value
+	transformer   atomic.Value
+	diag          Diagnostic
+
+	// StorageService saves the dedup state when PersistDedup is enabled, without it the state is not saved.
+	StorageService interface {
+		Store(namespace string) storage.Interface
+	}
+
+	clock       clock
+	dedup       *dedupCache
+	deadLetters *deadLetterQueue
+	flaps       *flapDamper
+	active      *activeAlerts
+	health      *endpointHealth
+
+	watchdogStop chan struct{}
+	replayStop   chan struct{}
+	resendStop   chan struct{}
+
+	readinessStop chan struct{}
+	// notReady is non-zero when the most recent readiness probe found Alertmanager not ready.
+	notReady int32
+	gated    *deadLetterQueue
+
+	asyncStop chan struct{}
+	// asyncDone is closed once the delivery worker stopped by asyncStop has exited.
+	asyncDone chan struct{}
+	queue     *deliveryQueue
+	startup   *startupHold
+
+	batchStop    chan struct{}
+	batches      *batcher
+	batchKeyTmpl atomic.Value
+	messageTmpl  atomic.Value
+
+	discoveryStop chan struct{}
+	lookupSRV     srvLookup
+	// targets holds the discoveredTargets resolved from SRVRecord.
+	targets atomic.Value
+
+	statsKey    string
+	statMap     *expvar.Map
+	cardinality *cardinalityStats
+	labelGuard  *labelGuard
+	requestLog  requestLogger
+}
+
+// Transformer post-processes the alerts immediately before they are marshalled and sent.
+type Transformer func(PostAlertManager) PostAlertManager
+
+// noopTransformer is the default Transformer and returns the alerts unchanged.
+func noopTransformer(alerts PostAlertManager) PostAlertManager {
+	return alerts
 }
 
 type AlertmanagerRequest struct {
@@ -43,25 +168,109 @@ type AlertmanagerAnnotations struct {
 	Severity string `json:"severity"`
 }
 
+// ClientTimeoutError is returned when Kapacitor gave up waiting for Alertmanager,
+// either because the configured timeout elapsed or the request context was done.
+type ClientTimeoutError struct {
+	Err error
+}
+
+func (e *ClientTimeoutError) Error() string {
+	return fmt.Sprintf("timed out waiting for Alertmanager: %v", e.Err)
+}
+
+// ServerClosedError is returned when Alertmanager closed or reset the connection
+// before sending a response.
+type ServerClosedError struct {
+	Err error
+}
+
+func (e *ServerClosedError) Error() string {
+	return fmt.Sprintf("Alertmanager closed the connection: %v", e.Err)
+}
+
 func NewService(c Config, d Diagnostic) *Service {
 	s := &Service{
-		diag: d,
+		diag:        d,
+		clock:       wallClock{},
+		dedup:       newDedupCache(),
+		deadLetters: new(deadLetterQueue),
+		gated:       new(deadLetterQueue),
+		startup:     new(startupHold),
+		batches:     newBatcher(),
+		flaps:       newFlapDamper(),
+		active:      newActiveAlerts(),
+		health:      newEndpointHealth(),
+		lookupSRV:   net.DefaultResolver.LookupSRV,
 	}
-	s.configValue.Store(c)
+	s.storeConfig(c)
+	s.transformer.Store(Transformer(noopTransformer))
+	s.statsKey, s.statMap = vars.NewStatistic("alertmanager", nil)
+	s.cardinality = newCardinalityStats(s.statMap)
+	s.labelGuard = newLabelGuard()
+	s.queue = newDeliveryQueue(s.statMap)
 	return s
 }
 
 func (s *Service) Open() error {
-	// Perform any initialization needed here
+	if c := s.config(); c.Enabled && c.CheckOnStart {
+		s.checkConnectivity()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opened = true
+	c := s.config()
+	s.startWatchdog(c)
+	s.startReplay(c)
+	s.startResend(c)
+	s.startReadiness(c)
+	s.startAsync(c)
+	s.startDiscovery(c)
+	s.startStartupHold(c)
+	s.startBatch(c)
+	if s.persistDedup(c) {
+		s.loadDedup(c)
+	}
 	return nil
 }
 
 func (s *Service) Close() error {
-	// Perform any actions needed to properly close the service here.
-	// For example signal and wait for all go routines to finish.
+	// Held alerts are sent before the delivery loops stop.
+	s.releaseStartupHold(s.startup.end())
+	s.flaps.flush()
+	s.mu.Lock()
+	s.opened = false
+	s.stopWatchdog()
+	s.stopReplay()
+	s.stopResend()
+	s.stopReadiness()
+	s.stopAsync()
+	s.stopDiscovery()
+	s.stopBatch()
+	s.mu.Unlock()
+	s.wg.Wait()
+	if c := s.config(); c.Enabled && c.ResolveOnClose {
+		s.resolveActive(context.Background(), c)
+	}
+	if c := s.config(); s.persistDedup(c) {
+		s.saveDedup(c)
+	}
+	vars.DeleteStatistic(s.statsKey)
 	return nil
 }
 
+// newClient creates the HTTP client used to talk to alertmanager.
+func newClient(c Config) *http.Client {
+	t := khttp.NewDefaultTransport()
+	if c.ForceHTTP1 {
+		// A non-nil empty map prevents the transport from upgrading TLS connections to HTTP/2.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return &http.Client{
+		Transport: t,
+		Timeout:   time.Duration(c.Timeout),
+	}
+}
+
 func (s *Service) Update(newConfig []interface{}) error {
 	if l := len(newConfig); l != 1 {
 		return fmt.Errorf("expected only one new config object, got %d", l)
@@ -69,41 +278,171 @@ func (s *Service) Update(newConfig []interface{}) error {
 	if c, ok := newConfig[0].(Config); !ok {
 		return fmt.Errorf("expected config object to be of type %T, got %T", c, newConfig[0])
 	} else {
-		s.configValue.Store(c)
+		s.storeConfig(c)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.opened {
+			s.stopWatchdog()
+			s.startWatchdog(c)
+			s.stopReplay()
+			s.startReplay(c)
+			s.stopResend()
+			s.startResend(c)
+			s.stopReadiness()
+			s.startReadiness(c)
+			if c.Enabled && !c.GateOnReadiness && s.gated.Len() > 0 {
+				// The alerts buffered while gating was enabled are no longer held back.
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					s.flushGated(context.Background(), c)
+				}()
+			}
+			s.stopAsync()
+			s.startAsync(c)
+			s.stopDiscovery()
+			s.startDiscovery(c)
+			s.stopBatch()
+			s.startBatch(c)
+		}
 	}
 	return nil
 }
 
-// config loads the config struct stored in the configValue field.
+// settings are a config and the client and drop selector created for it.
+type settings struct {
+	config       Config
+	client       *http.Client
+	dropSelector selector
+}
+
+// storeConfig stores the config and a client for it, removing duplicate URLs so that
+// alerts are not sent twice to the same endpoint. Sends already in flight keep the client they started with.
+func (s *Service) storeConfig(c Config) {
+	c, dups := c.withoutDuplicateURLs()
+	for _, u := range dups {
+		s.diag.Warn("ignoring duplicate alertmanager URL", keyvalue.KV("url", u))
+	}
+	dropSelector, err := parseSelector(c.DropSelector)
+	if err != nil {
+		s.diag.Error("invalid drop-selector, no alerts are dropped", err)
+	}
+	s.settingsValue.Store(&settings{config: c, client: newClient(c), dropSelector: dropSelector})
+}
+
+// settings loads the settings stored in the settingsValue field.
+func (s *Service) settings() *settings {
+	return s.settingsValue.Load().(*settings)
+}
+
+// config loads the config of the current settings.
 func (s *Service) config() Config {
-	return s.configValue.Load().(Config)
+	return s.settings().config
+}
+
+// RegisterTransformer sets the Transformer applied to every payload before it is sent.
+// Passing nil restores the no-op default.
+func (s *Service) RegisterTransformer(t Transformer) {
+	if t == nil {
+		t = noopTransformer
+	}
+	s.transformer.Store(t)
+}
+
+// MarshalJSON omits StartsAt and EndsAt when they are not set.
+func (a AlertManagerAlert) MarshalJSON() ([]byte, error) {
+	type wireAlert struct {
+		Status      string            `json:"status"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		StartsAt    *time.Time        `json:"startsAt,omitempty"`
+		EndsAt      *time.Time        `json:"endsAt,omitempty"`
+	}
+	w := wireAlert{
+		Status:      a.Status,
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+	}
+	if !a.StartsAt.IsZero() {
+		w.StartsAt = &a.StartsAt
+	}
+	if !a.EndsAt.IsZero() {
+		w.EndsAt = &a.EndsAt
+	}
+	return json.Marshal(w)
 }
 
 type PostAlertManager []AlertManagerAlert
 type AlertManagerAlert struct {
-	Status      string
-	Labels      map[string]string
-	Annotations map[string]string
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// StartsAt and EndsAt are sent when set, Alertmanager defaults them otherwise.
+	StartsAt time.Time `json:"startsAt"`
+	EndsAt   time.Time `json:"endsAt"`
+
+	// id, level and task describe the Kapacitor alert the alert was built from, they are not sent.
+	id    string
+	level alert.Level
+	task  string
+	// watchdog marks heartbeat alerts, which are never deduplicated.
+	watchdog bool
 }
 
 // Alert sends a to alertmanager .
 func (s *Service) Alert(tagName []string, tagValue []string, annotationName []string, annotationValue []string, alertLevel interface{}) error {
+	return s.alert(context.Background(), tagName, tagValue, annotationName, annotationValue, alertLevel, sendOptions{})
+}
+
+// AlertContext is like Alert but sends the alert with the given context,
+// propagating any span set with ContextWithSpan to Alertmanager.
+// Alerts sent by handlers carry no trace context, Kapacitor has no span to propagate.
+func (s *Service) AlertContext(ctx context.Context, tagName []string, tagValue []string, annotationName []string, annotationValue []string, alertLevel interface{}) error {
+	return s.alert(ctx, tagName, tagValue, annotationName, annotationValue, alertLevel, sendOptions{})
+}
+
+func (s *Service) alert(ctx context.Context, tagName []string, tagValue []string, annotationName []string, annotationValue []string, alertLevel interface{}, opts sendOptions) error {
 	c := s.config()
+	l, ok := alertLevel.(alert.Level)
+	if !ok || l < alert.OK {
+		l = c.defaultLevel()
+	}
+	newAlert, err := newAlertManagerAlert(tagName, tagValue, annotationName, annotationValue, l)
+	if err != nil {
+		return err
+	}
+	newAlert.Labels = c.mergeLabels(newAlert.Labels, l)
+	setRequiredLabels(newAlert, c.RequiredLabels)
+	if c.SeverityAnnotation {
+		setSeverity(newAlert, l)
+	}
+	return s.post(ctx, PostAlertManager{newAlert}, opts)
+}
+
+// sendOptions alter how a payload is sent.
+type sendOptions struct {
+	// test is set for payloads sent by Test.
+	test bool
+	// indent pretty prints the JSON body, it is only used by Test.
+	indent bool
+	// client sends the requests, it is the client of the config the send started with and is set by post.
+	client *http.Client
+}
+
+// newAlertManagerAlert builds an alert from parallel slices of label and annotation names and values.
+func newAlertManagerAlert(tagName []string, tagValue []string, annotationName []string, annotationValue []string, alertLevel alert.Level) (AlertManagerAlert, error) {
 	if len(tagName) != len(tagValue) {
-		return errors.New("Lenght of tagName and tagValue is not equal")
+		return AlertManagerAlert{}, errors.New("Lenght of tagName and tagValue is not equal")
 	}
 	if len(annotationName) != len(annotationValue) {
-		return errors.New("Lenght of annotationName and annotationValue is not equal")
+		return AlertManagerAlert{}, errors.New("Lenght of annotationName and annotationValue is not equal")
 	}
 
-	if !c.Enabled {
-		return errors.New("service is not enabled")
+	alertStatus := statusFiring
+	if alertLevel == alert.OK {
+		alertStatus = statusResolved
 	}
-
-	alertStatus := "firing"
-	//if alertLevel == alert.OK {
-	//	alertStatus = "resolved"
-	//}
 	alertLabels := map[string]string{}
 	for i := 0; i < len(tagName); i++ {
 		alertLabels[tagName[i]] = tagValue[i]
@@ -114,30 +453,397 @@ func (s *Service) Alert(tagName []string, tagValue []string, annotationName []st
 		alertAnnotations[annotationName[i]] = annotationValue[i]
 	}
 
-	newAlert := AlertManagerAlert{
+	return AlertManagerAlert{
 		Status:      alertStatus,
 		Labels:      alertLabels,
 		Annotations: alertAnnotations,
+		level:       alertLevel,
+	}, nil
+}
+
+// post sends the alerts to the configured alertmanager URL.
+func (s *Service) post(ctx context.Context, postMessage PostAlertManager, opts sendOptions) error {
+	st := s.settings()
+	c := st.config
+	opts.client = st.client
+	if !c.Enabled {
+		return errors.New("service is not enabled")
 	}
 
-	postMessage := PostAlertManager{newAlert}
+	preset := receiverPresets[c.Receiver]
+	var version string
+	if c.IncludeVersion {
+		version = vars.Info.Version()
+	}
+	for _, a := range postMessage {
+		preset.applyLabels(a)
+		if c.TestLabel != "" && (opts.test || c.ShadowMode) {
+			a.Labels[c.TestLabel] = "true"
+		}
+		if version != "" {
+			setDefault(a.Labels, versionLabel, version)
+		}
+		if c.GroupLabel != "" && len(c.GroupByLabels) > 0 {
+			if v := groupValue(a.Labels, c.GroupByLabels); v != "" {
+				setDefault(a.Labels, c.GroupLabel, v)
+			}
+		}
+		if c.SanitizeLabelWhitespace != "" {
+			for k, v := range a.Labels {
+				a.Labels[k] = sanitizeLabelValue(v, c.SanitizeLabelWhitespace)
+			}
+		}
+		if c.MaxLabels > 0 {
+			if dropped := capLabels(a.Labels, c.MaxLabels, c.LabelPriority); len(dropped) > 0 {
+				s.diag.Warn("alert exceeds max-labels, dropped labels", keyvalue.KV("labels", strings.Join(dropped, ",")))
+			}
+		}
+	}
 
-	data, err := json.Marshal(postMessage)
+	if len(st.dropSelector) > 0 {
+		var dropped int
+		if postMessage, dropped = st.dropSelector.drop(postMessage); dropped > 0 {
+			s.statMap.Add(statSelectorDropped, int64(dropped))
+		}
+		if len(postMessage) == 0 {
+			return nil
+		}
+	}
+	if c.IncludeRelated {
+		relate(postMessage, c.GroupByLabels)
+	}
+
+	now := s.clock.Now()
+	if c.MaxLabelCardinality > 0 {
+		var dropped []string
+		postMessage, dropped = s.labelGuard.filter(postMessage, c.MaxLabelCardinality, now, time.Duration(c.LabelCardinalityWindow))
+		for _, l := range dropped {
+			s.diag.Warn("label exceeded max-label-cardinality, it is no longer forwarded", keyvalue.KV("label", l))
+		}
+	}
+	var dedupKeys []string
+	if c.DedupInterval > 0 {
+		postMessage, dedupKeys = s.deduplicate(c, postMessage, now)
+		if len(postMessage) == 0 {
+			return nil
+		}
+	}
+
+	var firstErr error
+	for _, g := range groupByURL(c, postMessage, dedupKeys) {
+		if err := s.postGroup(ctx, c, preset, g, now, opts); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// alertGroup is a set of alerts sent to the same base URL in a single request.
+type alertGroup struct {
+	URL    string
+	Alerts PostAlertManager
+	// DedupKeys are the dedup keys of Alerts, empty for alerts that are not deduplicated.
+	DedupKeys []string
+}
+
+// groupByURL groups the alerts by the base URL for their level, preserving their order.
+func groupByURL(c Config, alerts PostAlertManager, dedupKeys []string) []*alertGroup {
+	var groups []*alertGroup
+	byURL := make(map[string]*alertGroup)
+	for i, a := range alerts {
+		u := c.levelURL(a.level)
+		g, ok := byURL[u]
+		if !ok {
+			g = &alertGroup{URL: u}
+			byURL[u] = g
+			groups = append(groups, g)
+		}
+		g.Alerts = append(g.Alerts, a)
+		var key string
+		if dedupKeys != nil {
+			key = dedupKeys[i]
+		}
+		g.DedupKeys = append(g.DedupKeys, key)
+	}
+	return groups
+}
+
+// postGroup transforms, encodes and sends a group of alerts.
+func (s *Service) postGroup(ctx context.Context, c Config, preset receiverPreset, g *alertGroup, now time.Time, opts sendOptions) error {
+	postMessage := s.transformer.Load().(Transformer)(g.Alerts)
+	if c.TimeRounding > 0 {
+		postMessage = roundStartsAt(postMessage, time.Duration(c.TimeRounding))
+	}
+	data, err := encode(c, postMessage, opts)
+	if err != nil {
+		s.statMap.Add(statMarshalErrors, 1)
+		if c.OnMarshalError != OnMarshalErrorSanitize {
+			return err
+		}
+		postMessage = sanitize(postMessage)
+		if data, err = encode(c, postMessage, opts); err != nil {
+			return err
+		}
+	}
+	if c.MaxBodyBytes > 0 && len(data) > c.MaxBodyBytes {
+		if len(g.Alerts) > 1 {
+			return s.postSplit(ctx, c, preset, g, now, opts)
+		}
+		if postMessage, data, err = s.truncate(c, postMessage, opts); err != nil {
+			return err
+		}
+	}
+	s.cardinality.observe(postMessage, now, time.Duration(c.CardinalityWindow))
+
+	method := c.HTTPMethod
+	if method == "" {
+		method = http.MethodPost
+	}
+	var pathKey string
+	if method == http.MethodPut && c.AppendKeyToPath {
+		pathKey = payloadKey(postMessage, c.DedupLabels)
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", contentType(c))
+	for k, v := range preset.Headers {
+		header.Set(k, v)
+	}
+	for k, v := range c.Headers {
+		header.Set(k, v)
+	}
+	if c.SigningKey != "" {
+		header.Set(c.SignatureHeader, sign(c.SigningKey, data))
+	}
+	if c.PartitionKeyLabel != "" {
+		if key := partitionKey(postMessage, c.PartitionKeyLabel); key != "" {
+			header.Set(c.PartitionKeyHeader, key)
+		}
+	}
+	request := func(base string) (outboundRequest, error) {
+		u, err := c.requestURL(base, pathKey)
+		if err != nil {
+			return outboundRequest{}, err
+		}
+		return outboundRequest{
+			Method:   method,
+			URL:      u,
+			Header:   c.endpointHeader(header, base),
+			Body:     data,
+			Endpoint: base,
+			Client:   opts.client,
+		}, nil
+	}
+	endpoints := s.endpoints(c, g.URL)
+	if c.EndpointStrategy == EndpointFailover && c.EndpointHealthWindow > 0 {
+		endpoints = s.health.order(endpoints)
+	}
+	if c.EndpointStrategy == EndpointFailover && c.RetryEndpointStrategy == RetryRotate {
+		ors := make([]outboundRequest, len(endpoints))
+		for i, base := range endpoints {
+			if ors[i], err = request(base); err != nil {
+				return err
+			}
+		}
+		err = s.sendRotating(ctx, c, ors)
+		err = s.sendFallback(ctx, c, request, g.URL, err)
+	} else {
+		err = s.sendToEndpoints(ctx, c, endpoints, func(base string) error {
+			or, err := request(base)
+			if err != nil {
+				return err
+			}
+			ectx, cancel := c.endpointContext(ctx)
+			defer cancel()
+			return s.sendFallback(ctx, c, request, base, s.sendWithRetry(ectx, c, or))
+		})
+	}
 	if err != nil {
 		return err
 	}
+	for i, key := range g.DedupKeys {
+		if key != "" {
+			s.dedup.record(key, g.Alerts[i].Status, now, time.Duration(c.DedupInterval))
+		}
+	}
+	return nil
+}
 
-	r, err := http.Post(c.URL, "application/json", bytes.NewReader(data))
+// deduplicate removes alerts that were already sent with the same status within the dedup interval.
+// It returns the remaining alerts along with their dedup keys.
+func (s *Service) deduplicate(c Config, alerts PostAlertManager, now time.Time) (PostAlertManager, []string) {
+	filtered := make(PostAlertManager, 0, len(alerts))
+	keys := make([]string, 0, len(alerts))
+	for _, a := range alerts {
+		if a.watchdog {
+			filtered = append(filtered, a)
+			keys = append(keys, "")
+			continue
+		}
+		key := dedupKey(a.Labels, c.DedupLabels)
+		if s.dedup.isDuplicate(key, a.Status, now, time.Duration(c.DedupInterval)) {
+			continue
+		}
+		filtered = append(filtered, a)
+		keys = append(keys, key)
+	}
+	return filtered, keys
+}
+
+// sendToEndpoints sends to the endpoints according to EndpointStrategy.
+// With fanout every endpoint is sent to concurrently, so that a slow endpoint does not delay the others,
+// and the error of the first failed endpoint in order is returned.
+// With failover endpoints are tried in order until one succeeds and the last error is returned.
+func (s *Service) sendToEndpoints(ctx context.Context, c Config, endpoints []string, send func(base string) error) error {
+	if c.EndpointStrategy == EndpointFailover {
+		var lastErr error
+		for _, base := range endpoints {
+			if lastErr = send(base); lastErr == nil {
+				return nil
+			}
+		}
+		return lastErr
+	}
+	if len(endpoints) == 1 {
+		return send(endpoints[0])
+	}
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, base := range endpoints {
+		wg.Add(1)
+		go func(i int, base string) {
+			defer wg.Done()
+			errs[i] = send(base)
+		}(i, base)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendFallback sends the request to FallbackURL when err is a failure to resolve the host of base,
+// otherwise it returns err.
+func (s *Service) sendFallback(ctx context.Context, c Config, request func(base string) (outboundRequest, error), base string, err error) error {
+	var dnsErr *net.DNSError
+	if c.FallbackURL == "" || base == c.FallbackURL || !errors.As(err, &dnsErr) {
+		return err
+	}
+	s.diag.Warn("failed to resolve alertmanager URL, sending to fallback-url", keyvalue.KV("url", base), keyvalue.KV("error", err.Error()))
+	s.statMap.Add(statFallbackSends, 1)
+	or, err := request(c.FallbackURL)
 	if err != nil {
 		return err
 	}
+	ctx, cancel := c.endpointContext(ctx)
+	defer cancel()
+	return s.sendWithRetry(ctx, c, or)
+}
+
+// groupValue joins the sorted names and values of the labels that are set, e.g. "cluster=east,service=db".
+func groupValue(labels map[string]string, names []string) string {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		if v := labels[name]; v != "" {
+			parts = append(parts, name+"="+v)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// partitionKey returns the value of the label on the first alert that has it.
+func partitionKey(alerts PostAlertManager, label string) string {
+	for _, a := range alerts {
+		if v := a.Labels[label]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// outboundRequest holds everything needed to (re)send a request to alertmanager.
+type outboundRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Endpoint is the base URL the request is sent to, used to track its health.
+	Endpoint string
+	// Client sends the request.
+	Client *http.Client
+}
+
+// statusCodeError is returned when alertmanager responds with a status code that is not a success.
+type statusCodeError struct {
+	StatusCode int
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("unexpected response code %d from Alertmanager service", e.StatusCode)
+}
+
+// send makes a single attempt at delivering the request,
+// recording the outcome in the health of its endpoint when EndpointHealthWindow is set.
+func (s *Service) send(ctx context.Context, c Config, or outboundRequest) error {
+	err := s.sendOnce(ctx, c, or)
+	if c.EndpointHealthWindow > 0 && or.Endpoint != "" && !c.ShadowMode {
+		s.health.record(or.Endpoint, err != nil, c.EndpointHealthWindow)
+	}
+	return err
+}
+
+// sendOnce performs the request, see send.
+func (s *Service) sendOnce(ctx context.Context, c Config, or outboundRequest) error {
+	req, err := http.NewRequest(or.Method, or.URL, bytes.NewReader(or.Body))
+	if err != nil {
+		return err
+	}
+	for k, v := range or.Header {
+		req.Header[k] = v
+	}
+	injectSpan(ctx, req.Header)
+	if c.LogRequests {
+		s.logRequest(c, outboundRequest{Method: or.Method, URL: or.URL, Header: req.Header, Body: or.Body})
+	}
+	if c.ShadowMode {
+		// The request is counted as if it was delivered, without being made.
+		s.statMap.Add(statShadowRequests, 1)
+		return nil
+	}
+	r, err := or.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return s.classifyError(err)
+	}
 	r.Body.Close()
-	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected response code %d from Alertmanager service", r.StatusCode)
+	if !c.isSuccess(r.StatusCode) {
+		return &statusCodeError{StatusCode: r.StatusCode}
 	}
 	return nil
 }
 
+// classifyError maps transport errors to a ClientTimeoutError or ServerClosedError
+// and counts them, so failures of the alerting pipeline itself can be told apart.
+func (s *Service) classifyError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled),
+		errors.As(err, &netErr) && netErr.Timeout():
+		s.statMap.Add(statClientTimeouts, 1)
+		return &ClientTimeoutError{Err: err}
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET):
+		s.statMap.Add(statServerClosed, 1)
+		return &ServerClosedError{Err: err}
+	}
+	return err
+}
+
 type HandlerConfig struct {
 	// tag name for alert in alertmanager
 	AlertManagerTagName []string `mapstructure:"alertManagerTagName"`
@@ -146,7 +852,68 @@ type HandlerConfig struct {
 	// annotation name for alert in alertmanager
 	AlertManagerAnnotationName []string `mapstructure:"alertManagerAnnotationName"`
 	// annotation value for alert in alertmanager
-	AlertManagerAnnotationValue []string `mapstructure: "alertManagerAnnotationValue"`
+	AlertManagerAnnotationValue []string `mapstructure:"alertManagerAnnotationValue"`
+	// Log a warning instead of failing validation when a label name is listed twice.
+	// The last value for the label wins.
+	AllowDuplicateLabels bool `mapstructure:"allow-duplicate-labels"`
+	// Minimum level of events to forward, e.g. "critical". Resolves of forwarded alerts are always sent.
+	MinLevel string `mapstructure:"min-level"`
+	// Prefix removed from label names, e.g. "k8s_". A name is kept as is if stripping
+	// the prefix would leave an invalid label name or collide with another label.
+	StripLabelPrefix string `mapstructure:"strip-label-prefix"`
+	// Serialize sends for the same alert ID, so that concurrent events for an alert are delivered in order.
+	// Events older than one already sent for the alert are dropped.
+	SerializeByID bool `mapstructure:"serialize-by-id"`
+	// Template for the alert status, overriding firing for events and resolved for OK events.
+	StatusTemplate string `mapstructure:"status-template"`
+	// Template for the alertname label, e.g. "{{ .Name }}_{{ index .Tags \"host\" }}".
+	// The name rendered when the alert fired is reused for its resolve.
+	AlertNameTemplate string `mapstructure:"alert-name-template"`
+	// Predicate over the event fields and tags that must be true for the event to be sent,
+	// e.g. `"value" > 90.0`. Resolves are sent regardless, so firing alerts always clear.
+	SendIf string `mapstructure:"send-if"`
+	// Routing value sent as the Config.RoomLabel label, e.g. a receiver channel.
+	Room string `mapstructure:"room"`
+	// Thresholds crossed by the alert, by level, e.g. {"warning": "80", "critical": "90"}.
+	// The threshold of the event level is sent as the threshold annotation of firing alerts.
+	Thresholds map[string]string `mapstructure:"thresholds"`
+	// Called with the send error when Config.OnFailure is "propagate",
+	// e.g. to count the failure against the alert node.
+	OnError func(error) `mapstructure:"-"`
+}
+
+// Validate ensures the handler configuration is usable.
+func (c HandlerConfig) Validate() error {
+	if dup := c.duplicateLabel(); dup != "" && !c.AllowDuplicateLabels {
+		return fmt.Errorf("duplicate label name %q in alertManagerTagName", dup)
+	}
+	if c.MinLevel != "" {
+		if _, err := alert.ParseLevel(c.MinLevel); err != nil {
+			return fmt.Errorf("invalid min-level: %v", err)
+		}
+	}
+	for name := range c.Thresholds {
+		l, err := alert.ParseLevel(name)
+		if err != nil {
+			return fmt.Errorf("invalid thresholds level: %v", err)
+		}
+		if l == alert.OK {
+			return fmt.Errorf("invalid thresholds level %q, an OK event crosses no threshold", name)
+		}
+	}
+	return nil
+}
+
+// duplicateLabel returns the first label name listed more than once, or an empty string.
+func (c HandlerConfig) duplicateLabel() string {
+	seen := make(map[string]bool, len(c.AlertManagerTagName))
+	for _, name := range c.AlertManagerTagName {
+		if seen[name] {
+			return name
+		}
+		seen[name] = true
+	}
+	return ""
 }
 
 // handler provides the implementation of the alert.Handler interface for the Foo service.
@@ -155,10 +922,46 @@ type handler struct {
 	c    HandlerConfig
 	diag Diagnostic
 
+	// minLevel is the parsed MinLevel.
+	minLevel alert.Level
+	// thresholds are the parsed Thresholds.
+	thresholds map[alert.Level]string
+	// node is the name of the alert node from the handler context, if any.
+	node string
+	// name is the ID of the named handler from the handler context, if any.
+	name string
+	// database and rp are the database and retention policy of the task from the handler context, if any.
+	database string
+	rp       string
+	// query is the query of the batch task from the handler context, if any.
+	query string
+
+	mu  sync.Mutex
+	err error
+	// forwarded are the IDs of alerts sent while firing when MinLevel is set.
+	forwarded map[string]bool
+
+	// endsAt are the firing alerts sent with an endsAt, by ID, when EndsAtWindow is set.
+	endsAt map[string]sentEndsAt
+
+	// lastStatus are the statuses last sent, by ID, when SuppressDuplicateResolves is set.
+	lastStatus map[string]sentStatus
+
+	// alertNames are the alertnames rendered for firing alerts, by ID, when AlertNameTemplate is set.
+	alertNames map[string]string
+
+	// values are the values last sent, by ID, when IncludePreviousValue is set.
+	values map[string]string
+
+	idLocks *keyedMutex
+
 	tagNametmpl   []*text.Template
 	tagValuetmpl  []*text.Template
 	annoNametmpl  []*text.Template
 	annoValuetmpl []*text.Template
+	statustmpl    *text.Template
+	alertnametmpl *text.Template
+	sendIf        *sendIf
 }
 
 // DefaultHandlerConfig returns a HandlerConfig struct with defaults applied.
@@ -167,9 +970,23 @@ func (s *Service) DefaultHandlerConfig() HandlerConfig {
 }
 
 func (s *Service) Handler(c HandlerConfig, ctx ...keyvalue.T) (alert.Handler, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	// The query is only used for the annotation, it is too long for the log context.
+	diag := s.diag.WithContext(withoutKey(ctx, "query")...)
+	if dup := c.duplicateLabel(); dup != "" {
+		diag.Warn("duplicate label name, the last value wins", keyvalue.KV("label", dup))
+	}
+	for _, name := range c.AlertManagerAnnotationName {
+		if reservedAnnotations[name] {
+			diag.Warn("annotation name is reserved, the handler value takes precedence", keyvalue.KV("annotation", name))
+		}
+	}
+
 	var tagNametmpl []*text.Template
 	for _, tagName := range c.AlertManagerTagName {
-		tmpl, err := text.New("service").Parse(tagName)
+		tmpl, err := newTemplate("service", tagName)
 		if err != nil {
 			return nil, err
 		}
@@ -177,7 +994,7 @@ func (s *Service) Handler(c HandlerConfig, ctx ...keyvalue.T) (alert.Handler, er
 	}
 	var tagValuetmpl []*text.Template
 	for _, tagValue := range c.AlertManagerTagValue {
-		tmpl, err := text.New("service").Parse(tagValue)
+		tmpl, err := newTemplate("service", tagValue)
 		if err != nil {
 			return nil, err
 		}
@@ -185,7 +1002,7 @@ func (s *Service) Handler(c HandlerConfig, ctx ...keyvalue.T) (alert.Handler, er
 	}
 	var annoNametmpl []*text.Template
 	for _, annoName := range c.AlertManagerAnnotationName {
-		tmpl, err := text.New("service").Parse(annoName)
+		tmpl, err := newTemplate("service", annoName)
 		if err != nil {
 			return nil, err
 		}
@@ -194,31 +1011,123 @@ func (s *Service) Handler(c HandlerConfig, ctx ...keyvalue.T) (alert.Handler, er
 
 	var annoValuetmpl []*text.Template
 	for _, annoValue := range c.AlertManagerAnnotationValue {
-		tmpl, err := text.New("service").Parse(annoValue)
+		tmpl, err := newTemplate("service", annoValue)
 		if err != nil {
 			return nil, err
 		}
 		annoValuetmpl = append(annoValuetmpl, tmpl)
 	}
 
+	var statustmpl *text.Template
+	if c.StatusTemplate != "" {
+		tmpl, err := newTemplate("status", c.StatusTemplate)
+		if err != nil {
+			return nil, err
+		}
+		statustmpl = tmpl
+	}
+	var alertnametmpl *text.Template
+	if c.AlertNameTemplate != "" {
+		tmpl, err := newTemplate("alertname", c.AlertNameTemplate)
+		if err != nil {
+			return nil, err
+		}
+		alertnametmpl = tmpl
+	}
+	var predicate *sendIf
+	if c.SendIf != "" {
+		p, err := newSendIf(c.SendIf)
+		if err != nil {
+			return nil, err
+		}
+		predicate = p
+	}
+
+	var minLevel alert.Level
+	if c.MinLevel != "" {
+		// Validate has already checked the level.
+		minLevel, _ = alert.ParseLevel(c.MinLevel)
+	}
+	var thresholds map[alert.Level]string
+	if len(c.Thresholds) > 0 {
+		thresholds = make(map[alert.Level]string, len(c.Thresholds))
+		for name, threshold := range c.Thresholds {
+			l, _ := alert.ParseLevel(name)
+			thresholds[l] = threshold
+		}
+	}
+
 	return &handler{
-		s:    s,
-		c:    c,
-		diag: s.diag.WithContext(ctx...),
+		s:          s,
+		c:          c,
+		diag:       diag,
+		minLevel:   minLevel,
+		thresholds: thresholds,
+		node:       contextValue(ctx, "node"),
+		name:       contextValue(ctx, "handler"),
+		database:   contextValue(ctx, "database"),
+		rp:         contextValue(ctx, "rp"),
+		query:      contextValue(ctx, "query"),
+		forwarded:  make(map[string]bool),
+		endsAt:     make(map[string]sentEndsAt),
+		lastStatus: make(map[string]sentStatus),
+		alertNames: make(map[string]string),
+		values:     make(map[string]string),
+		idLocks:    newKeyedMutex(),
 
 		tagNametmpl:   tagNametmpl,
 		tagValuetmpl:  tagValuetmpl,
 		annoNametmpl:  annoNametmpl,
 		annoValuetmpl: annoValuetmpl,
+		statustmpl:    statustmpl,
+		alertnametmpl: alertnametmpl,
+		sendIf:        predicate,
 	}, nil
 }
 
 // Handle takes an event and posts its message to the alertmanager
 func (h *handler) Handle(event alert.Event) {
+	if c := h.s.config(); !c.Enabled && c.SilentWhenDisabled {
+		return
+	}
+	if reason := invalidState(event); reason != "" {
+		h.diag.Warn("dropping event with an invalid state", keyvalue.KV("reason", reason))
+		return
+	}
+	if c := h.s.config(); c.MaxResolveAge > 0 && event.State.Level == alert.OK && !event.State.Time.IsZero() {
+		// A late resolve would reopen and close the alert in Alertmanager.
+		if age := h.s.clock.Now().Sub(event.State.Time); age > time.Duration(c.MaxResolveAge) {
+			h.diag.Warn("dropping resolve older than max-resolve-age", keyvalue.KV("age", age.String()))
+			return
+		}
+	}
+	if h.c.SerializeByID {
+		id := event.State.ID
+		l := h.idLocks.lock(id)
+		defer h.idLocks.unlock(id, l)
+		if event.State.Time.Before(l.last) {
+			// A newer event for the alert has already been handled.
+			return
+		}
+		l.last = event.State.Time
+	}
+	if h.sendIf != nil && event.State.Level != alert.OK {
+		ok, err := h.sendIf.eval(event)
+		if err != nil {
+			h.diag.Error("failed to evaluate send-if expression", err)
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	if !h.forward(event) {
+		return
+	}
 	td := event.TemplateData()
 	var buf bytes.Buffer
 	var err error
-	var tagName,tagValue,annoName, annoValue []string
+	var tagName, tagValue, annoName, annoValue []string
 	for _, tmpl := range h.tagNametmpl {
 		err = tmpl.Execute(&buf, td)
 		if err != nil {
@@ -257,9 +1166,373 @@ func (h *handler) Handle(event alert.Event) {
 		buf.Reset()
 	}
 
-	if err := h.s.Alert(tagName, tagValue, annoName, annoValue, event.State.Level); err != nil {
+	newAlert, err := newAlertManagerAlert(tagName, tagValue, annoName, annoValue, event.State.Level)
+	if err != nil {
 		h.diag.Error("E! failed to handle event", err)
+		return
+	}
+	newAlert.id = event.State.ID
+	newAlert.task = event.Data.TaskName
+	if h.statustmpl != nil {
+		if err := h.statustmpl.Execute(&buf, td); err != nil {
+			h.diag.TemplateError(err, keyvalue.KV("statusTemplate", h.c.StatusTemplate))
+			return
+		}
+		status := strings.TrimSpace(buf.String())
+		buf.Reset()
+		if status == "" {
+			h.diag.Error("E! failed to handle event", errors.New("status template rendered an empty status"))
+			return
+		}
+		newAlert.Status = status
+	}
+	if h.c.StripLabelPrefix != "" {
+		newAlert.Labels = stripLabelPrefix(newAlert.Labels, h.c.StripLabelPrefix)
+	}
+	if h.alertnametmpl != nil {
+		if err := h.alertnametmpl.Execute(&buf, td); err != nil {
+			h.diag.TemplateError(err, keyvalue.KV("alertNameTemplate", h.c.AlertNameTemplate))
+			return
+		}
+		name := h.alertName(event, sanitizeAlertName(buf.String()))
+		buf.Reset()
+		if name != "" {
+			setDefault(newAlert.Labels, alertNameLabel, name)
+		}
+	}
+
+	c := h.s.config()
+	newAlert.Labels = c.mergeLabels(newAlert.Labels, event.State.Level)
+	if c.EnrichmentLabel != "" {
+		for name, value := range c.Enrichment[newAlert.Labels[c.EnrichmentLabel]] {
+			setDefault(newAlert.Labels, name, value)
+		}
+	}
+	if c.IncludeMeasurement {
+		if m := measurement(event); m != "" {
+			newAlert.Labels[measurementLabel] = m
+		}
+	}
+	if c.GroupLabel != "" && event.Data.Group != "" && len(c.GroupByLabels) == 0 {
+		if _, ok := newAlert.Labels[c.GroupLabel]; !ok {
+			newAlert.Labels[c.GroupLabel] = event.Data.Group
+		}
+	}
+	if c.IncludeOrigin {
+		setDefault(newAlert.Labels, originLabel, origin)
+	}
+	for name, value := range map[string]string{
+		instanceLabel:    tagOrDefault(event, c.InstanceTag, c.Instance),
+		environmentLabel: c.Environment,
+		customerLabel:    tagOrDefault(event, c.CustomerField, c.Customer),
+		clusterLabel:     os.ExpandEnv(c.Cluster),
+		severityLabel:    c.severity(event),
+	} {
+		if _, ok := newAlert.Labels[name]; !ok && value != "" {
+			newAlert.Labels[name] = value
+		}
+	}
+	if c.SeverityAnnotation {
+		setSeverity(newAlert, event.State.Level)
+	}
+	if c.RoomLabel != "" && h.c.Room != "" {
+		setDefault(newAlert.Labels, c.RoomLabel, h.c.Room)
+	}
+	if c.IncludeDBRP && h.database != "" {
+		setDefault(newAlert.Labels, databaseLabel, h.database)
+		setDefault(newAlert.Labels, retentionPolicyLabel, h.rp)
+	}
+	if c.IncludeHandler && c.HandlerLabel != "" && h.name != "" {
+		setDefault(newAlert.Labels, c.HandlerLabel, h.name)
+	}
+	// Generated annotations never replace those set by the handler.
+	if c.IncludeNode && h.node != "" {
+		setDefault(newAlert.Annotations, nodeAnnotation, h.node)
+	}
+	if c.IncludeQuery && h.query != "" {
+		setDefault(newAlert.Annotations, queryAnnotation, truncateString(h.query, c.QueryMaxBytes))
+	}
+	if c.IncludeTransition && c.TransitionAnnotation != "" {
+		if t := transition(event); t != "" {
+			setDefault(newAlert.Annotations, c.TransitionAnnotation, t)
+		}
 	}
+	if u := c.urgency(event); u != "" {
+		setDefault(newAlert.Annotations, urgencyAnnotation, u)
+	}
+	if c.AutoSummary && event.State.Message != "" {
+		setDefault(newAlert.Annotations, summaryAnnotation, event.State.Message)
+	}
+	if c.MessageAnnotation {
+		if msg, ok := h.message(c, td); ok {
+			setDefault(newAlert.Annotations, messageAnnotation, msg)
+		}
+	}
+	if c.ValueField != "" {
+		if v, ok := formatValue(event, c.ValueField, c.ValuePrecision); ok {
+			setDefault(newAlert.Annotations, valueAnnotation, v)
+			if c.IncludePreviousValue {
+				if prev, ok := h.previousValue(event, v); ok {
+					setDefault(newAlert.Annotations, previousValueAnnotation, prev)
+				}
+			}
+		}
+	}
+	if threshold, ok := h.thresholds[event.State.Level]; ok && event.State.Level != alert.OK {
+		setDefault(newAlert.Annotations, thresholdAnnotation, threshold)
+	}
+	if c.IncludeRawEvent {
+		raw, ok, err := encodeRawEvent(event, c.RawEventMaxBytes)
+		if err != nil {
+			h.diag.Error("failed to encode raw event", err)
+		} else if ok {
+			setDefault(newAlert.Annotations, rawEventAnnotation, raw)
+		}
+	}
+	if c.TraceIDField != "" {
+		if id, ok := fieldOrTag(event, c.TraceIDField); ok {
+			setDefault(newAlert.Annotations, traceIDAnnotation, id)
+		}
+	}
+	for name, value := range c.Annotations {
+		setDefault(newAlert.Annotations, name, value)
+	}
+	if c.MaxAnnotations > 0 {
+		if dropped := capAnnotations(newAlert.Annotations, c.MaxAnnotations); len(dropped) > 0 {
+			h.diag.Warn("alert exceeds max-annotations, dropped annotations", keyvalue.KV("annotations", strings.Join(dropped, ",")))
+		}
+	}
+
+	if c.EndsAtWindow > 0 && newAlert.id != "" && !h.refreshEndsAt(c, &newAlert) {
+		return
+	}
+
+	postMessage := PostAlertManager{newAlert}
+	if c.FlapWindow > 0 && newAlert.id != "" {
+		k := activeKey{h: h, id: newAlert.id}
+		if newAlert.Status == statusResolved {
+			// Hold the resolve, it is sent once the window passes without the alert firing again.
+			h.s.flaps.hold(h.s.clock, k, time.Duration(c.FlapWindow), func() {
+				h.send(h.s.config(), postMessage)
+			})
+			return
+		}
+		if h.s.flaps.cancel(k) {
+			// The alert fired again within the window, Alertmanager still considers it firing.
+			return
+		}
+	}
+	h.send(c, postMessage)
+}
+
+// message renders the MessageTemplate for the event, ok is false if it fails or renders nothing.
+func (h *handler) message(c Config, td alert.TemplateData) (string, bool) {
+	t, err := loadTemplate(&h.s.messageTmpl, "message-template", c.MessageTemplate)
+	if err != nil {
+		h.diag.Error("failed to parse message-template", err)
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, td); err != nil {
+		h.diag.TemplateError(err, keyvalue.KV("messageTemplate", c.MessageTemplate))
+		return "", false
+	}
+	msg := strings.TrimSpace(buf.String())
+	return msg, msg != ""
+}
+
+// contextValue returns the value of the last context pair with the key.
+func contextValue(ctx []keyvalue.T, key string) string {
+	var v string
+	for _, kv := range ctx {
+		if kv.Key == key {
+			v = kv.Value
+		}
+	}
+	return v
+}
+
+// withoutKey returns the context pairs without those with the key.
+func withoutKey(ctx []keyvalue.T, key string) []keyvalue.T {
+	filtered := make([]keyvalue.T, 0, len(ctx))
+	for _, kv := range ctx {
+		if kv.Key != key {
+			filtered = append(filtered, kv)
+		}
+	}
+	return filtered
+}
+
+// forward reports whether the event meets MinLevel, or resolves an alert that was forwarded.
+func (h *handler) forward(event alert.Event) bool {
+	if h.minLevel == alert.OK {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := event.State.ID
+	if event.State.Level >= h.minLevel {
+		h.forwarded[id] = true
+		return true
+	}
+	if event.State.Level == alert.OK && h.forwarded[id] {
+		delete(h.forwarded, id)
+		return true
+	}
+	return false
+}
+
+// previousValue returns the value last seen for the alert of the event and remembers value in its place.
+// Values are forgotten once the alert resolves.
+func (h *handler) previousValue(event alert.Event, value string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := event.State.ID
+	prev, ok := h.values[id]
+	if event.State.Level == alert.OK {
+		delete(h.values, id)
+	} else {
+		h.values[id] = value
+	}
+	return prev, ok
+}
+
+// alertName returns the alertname for the event given the name rendered for it.
+// The name rendered when an alert fires is remembered and used for its resolve,
+// so that the resolve matches the firing alert in Alertmanager.
+func (h *handler) alertName(event alert.Event, rendered string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := event.State.ID
+	if event.State.Level == alert.OK {
+		if name, ok := h.alertNames[id]; ok {
+			delete(h.alertNames, id)
+			return name
+		}
+		return rendered
+	}
+	if rendered != "" {
+		h.alertNames[id] = rendered
+	}
+	return rendered
+}
+
+// sanitizeAlertName trims the name and replaces every run of characters other than
+// letters, digits, '_', '-', '.' and ':' with a single '_'.
+func sanitizeAlertName(name string) string {
+	var b strings.Builder
+	replaced := false
+	for _, r := range strings.TrimSpace(name) {
+		if r == '_' || r == '-' || r == '.' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			replaced = false
+			continue
+		}
+		if !replaced {
+			b.WriteByte('_')
+			replaced = true
+		}
+	}
+	return b.String()
+}
+
+// send delivers the alerts, or queues them for the delivery worker under AsyncDelivery.
+// Firing alerts are held until the startup suppression window has passed.
+func (h *handler) send(c Config, postMessage PostAlertManager) {
+	if c.StartupSuppressWindow > 0 && h.s.startup.hold(h, postMessage) {
+		h.s.statMap.Add(statStartupHeld, 1)
+		return
+	}
+	if c.AsyncDelivery {
+		h.s.enqueue(c, h, postMessage)
+		return
+	}
+	h.deliver(c, postMessage)
+}
+
+// deliver posts the alerts, applying the configured OnFailure behavior if they cannot be delivered.
+func (h *handler) deliver(c Config, postMessage PostAlertManager) {
+	now := h.s.clock.Now()
+	if c.SuppressDuplicateResolves && len(postMessage) == 1 && h.duplicateResolve(c, postMessage[0], now) {
+		return
+	}
+	if c.GateOnReadiness && !h.s.ready() {
+		h.s.gate(c, h, postMessage)
+		return
+	}
+	if c.BatchInterval > 0 {
+		h.s.batch(c, h, postMessage)
+		return
+	}
+	err := h.s.post(context.Background(), postMessage, sendOptions{})
+	h.delivered(c, postMessage, now, err)
+}
+
+// delivered records the outcome of sending the alerts at now.
+func (h *handler) delivered(c Config, postMessage PostAlertManager, now time.Time, err error) {
+	if err != nil {
+		h.handleFailure(c, postMessage, err)
+		return
+	}
+	if c.SuppressDuplicateResolves {
+		h.recordStatus(c, postMessage, now)
+	}
+	if c.EndsAtWindow > 0 {
+		h.recordEndsAt(postMessage)
+	}
+	if c.resendTick() > 0 || c.ResolveOnClose {
+		h.s.active.update(h, postMessage, now)
+	}
+	h.setErr(nil)
+}
+
+// stripLabelPrefix returns the labels with prefix removed from their names,
+// keeping names that would become invalid or collide with another label.
+func stripLabelPrefix(labels map[string]string, prefix string) map[string]string {
+	stripped := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if !strings.HasPrefix(k, prefix) {
+			stripped[k] = v
+		}
+	}
+	for k, v := range labels {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, prefix)
+		if _, collides := labels[name]; collides || !validLabelName(name) {
+			name = k
+		}
+		stripped[name] = v
+	}
+	return stripped
+}
+
+// validLabelName reports whether name is a valid Prometheus label name.
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// measurement returns the name of the measurement that triggered the event,
+// or an empty string if the event carries no series data.
+func measurement(event alert.Event) string {
+	if event.Data.Name != "" {
+		return event.Data.Name
+	}
+	for _, s := range event.Data.Result.Series {
+		if s != nil && s.Name != "" {
+			return s.Name
+		}
+	}
+	return ""
 }
 
 type testOptions struct {
@@ -268,6 +1541,8 @@ type testOptions struct {
 	AlertManagerTagValue        []string `json:"alertManagerTagValue"`
 	AlertManagerAnnotationName  []string `json:"alertManagerAnnotationName"`
 	AlertManagerAnnotationValue []string `json:"alertManagerAnnotationValue"`
+	// Indent pretty prints the JSON body of the test alert.
+	Indent bool `json:"indent"`
 }
 
 func (s *Service) TestOptions() interface{} {
@@ -284,5 +1559,11 @@ func (s *Service) Test(o interface{}) error {
 	if !ok {
 		return fmt.Errorf("unexpected options type %T", options)
 	}
-	return s.Alert(options.AlertManagerTagName, options.AlertManagerTagValue, options.AlertManagerAnnotationName, options.AlertManagerAnnotationValue, alert.Critical)
+	if s.config().Enabled {
+		s.checkConnectivity()
+	}
+	return s.alert(context.Background(), options.AlertManagerTagName, options.AlertManagerTagValue, options.AlertManagerAnnotationName, options.AlertManagerAnnotationValue, alert.Critical, sendOptions{
+		test:   true,
+		indent: options.Indent,
+	})
 }
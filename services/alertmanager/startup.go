@@ -0,0 +1,102 @@
+package alertmanager
+
+import (
+	"sync"
+	"time"
+)
+
+const statStartupHeld = "startup_held"
+
+// startupHold holds firing alerts sent during the startup suppression window,
+// so that states that existed before Kapacitor started do not arrive as a burst.
+type startupHold struct {
+	mu      sync.Mutex
+	holding bool
+	t       timer
+	items   []queuedDelivery
+}
+
+// begin starts holding firing alerts until window has passed, then calls release with them.
+func (sh *startupHold) begin(c clock, window time.Duration, release func([]queuedDelivery)) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.holding = true
+	sh.t = c.AfterFunc(window, func() {
+		release(sh.end())
+	})
+}
+
+// end stops holding alerts and returns the held ones in order.
+func (sh *startupHold) end() []queuedDelivery {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.t != nil {
+		sh.t.Stop()
+		sh.t = nil
+	}
+	sh.holding = false
+	items := sh.items
+	sh.items = nil
+	return items
+}
+
+// hold holds the alerts of h if they are firing and the window has not passed, reporting whether they were held.
+// A resolve is never held, it discards the held firing alert it resolves.
+func (sh *startupHold) hold(h *handler, alerts PostAlertManager) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if !sh.holding {
+		return false
+	}
+	if firing(alerts) {
+		sh.items = append(sh.items, queuedDelivery{h: h, alerts: alerts})
+		return true
+	}
+	kept := sh.items[:0]
+	for _, d := range sh.items {
+		if d.h != h || !sameIDs(d.alerts, alerts) {
+			kept = append(kept, d)
+		}
+	}
+	sh.items = kept
+	return false
+}
+
+// firing reports whether any of the alerts is firing.
+func firing(alerts PostAlertManager) bool {
+	for _, a := range alerts {
+		if a.Status != statusResolved {
+			return true
+		}
+	}
+	return false
+}
+
+// sameIDs reports whether both payloads are built from the same Kapacitor alerts.
+func sameIDs(a, b PostAlertManager) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].id != b[i].id {
+			return false
+		}
+	}
+	return true
+}
+
+// startStartupHold starts the startup suppression window. The caller must hold s.mu.
+func (s *Service) startStartupHold(c Config) {
+	if !c.Enabled || c.StartupSuppressWindow <= 0 {
+		return
+	}
+	s.startup.begin(s.clock, time.Duration(c.StartupSuppressWindow), s.releaseStartupHold)
+}
+
+// releaseStartupHold sends the alerts held during the startup suppression window.
+func (s *Service) releaseStartupHold(items []queuedDelivery) {
+	c := s.config()
+	for _, d := range items {
+		d.h.send(c, d.alerts)
+	}
+}
@@ -0,0 +1,68 @@
+package alertmanager
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	text "text/template"
+)
+
+// templateFuncs are the helper functions available to the handler templates,
+// in addition to the Go template builtins. Arguments follow the sprig order,
+// so that the value being transformed can be piped in last:
+//
+//	lower s               s in lower case
+//	upper s               s in upper case
+//	trimSpace s           s without leading and trailing white space
+//	trimPrefix prefix s   s without the leading prefix
+//	trimSuffix suffix s   s without the trailing suffix
+//	replace old new s     s with every old replaced by new
+//	default def v         v, or def when v is empty or missing
+//
+// The set is deliberately small, none of the functions have side effects.
+var templateFuncs = text.FuncMap{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trimSpace": strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"trimSuffix": func(suffix, s string) string {
+		return strings.TrimSuffix(s, suffix)
+	},
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"default": func(def interface{}, v ...interface{}) interface{} {
+		if len(v) == 0 || v[0] == nil || fmt.Sprint(v[0]) == "" {
+			return def
+		}
+		return v[0]
+	},
+}
+
+// newTemplate parses a handler template with the templateFuncs available.
+func newTemplate(name, tmpl string) (*text.Template, error) {
+	return text.New(name).Funcs(templateFuncs).Parse(tmpl)
+}
+
+// parsedTemplate is a template of a config field along with its source.
+type parsedTemplate struct {
+	Source string
+	Tmpl   *text.Template
+}
+
+// loadTemplate returns the template parsed from source, reusing the parsedTemplate stored in v
+// while the source is unchanged so that it is only parsed again after Update changes it.
+func loadTemplate(v *atomic.Value, name, source string) (*text.Template, error) {
+	pt, _ := v.Load().(parsedTemplate)
+	if pt.Tmpl != nil && pt.Source == source {
+		return pt.Tmpl, nil
+	}
+	t, err := newTemplate(name, source)
+	if err != nil {
+		return nil, err
+	}
+	v.Store(parsedTemplate{Source: source, Tmpl: t})
+	return t, nil
+}
@@ -0,0 +1,57 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+)
+
+// SpanContext identifies a span in a distributed trace, following the W3C Trace Context specification.
+// Kapacitor has no tracer of its own, callers of Service.AlertContext set the span of their trace
+// with ContextWithSpan.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+	// TraceState is the vendor specific trace state, sent as the tracestate header if set.
+	TraceState string
+	// Baggage is the W3C baggage, sent as the baggage header if set.
+	Baggage string
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// traceParent returns the value of the traceparent header for the span.
+func (sc SpanContext) traceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying the span, so that the request sent by
+// Service.AlertContext with it propagates the trace context to Alertmanager.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// injectSpan sets the trace context headers for the span carried by ctx, if any.
+func injectSpan(ctx context.Context, h http.Header) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	if !ok || !sc.IsValid() {
+		return
+	}
+	h.Set("traceparent", sc.traceParent())
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	}
+	if sc.Baggage != "" {
+		h.Set("baggage", sc.Baggage)
+	}
+}
@@ -0,0 +1,58 @@
+package alertmanager
+
+import (
+	"context"
+	"time"
+)
+
+// watchdogAlert returns the always-firing heartbeat alert.
+func watchdogAlert(c Config) AlertManagerAlert {
+	labels := make(map[string]string, len(c.WatchdogLabels)+1)
+	for k, v := range c.WatchdogLabels {
+		labels[k] = v
+	}
+	if labels[alertNameLabel] == "" {
+		labels[alertNameLabel] = defaultWatchdogAlertName
+	}
+	return AlertManagerAlert{
+		Status:      statusFiring,
+		Labels:      labels,
+		Annotations: map[string]string{},
+		id:          labels[alertNameLabel],
+		watchdog:    true,
+	}
+}
+
+// startWatchdog starts sending heartbeats on the configured interval until stopWatchdog is called.
+// The caller must hold s.mu.
+func (s *Service) startWatchdog(c Config) {
+	if !c.Enabled || !c.Watchdog {
+		return
+	}
+	t := s.clock.NewTicker(time.Duration(c.WatchdogInterval))
+	stop := make(chan struct{})
+	s.watchdogStop = stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				if err := s.post(context.Background(), PostAlertManager{watchdogAlert(s.config())}, sendOptions{}); err != nil {
+					s.diag.Error("failed to send watchdog alert", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopWatchdog stops any running watchdog. The caller must hold s.mu.
+func (s *Service) stopWatchdog() {
+	if s.watchdogStop != nil {
+		close(s.watchdogStop)
+		s.watchdogStop = nil
+	}
+}
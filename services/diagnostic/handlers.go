@@ -417,6 +417,15 @@ func (h *AlertManagerHandler) Error(msg string, err error) {
 	h.l.Error(msg, Error(err))
 }
 
+// Warn logs at info level since the logger has no dedicated warning level.
+func (h *AlertManagerHandler) Warn(msg string, ctx ...keyvalue.T) {
+	Info(h.l, msg, ctx)
+}
+
+func (h *AlertManagerHandler) Debug(msg string, ctx ...keyvalue.T) {
+	Debug(h.l, msg, ctx)
+}
+
 // HipChat handler
 type HipChatHandler struct {
 	l Logger